@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/joyent/tritonnfs-csi/pkg/fakecloudapi"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	listen        = flag.String("listen", "127.0.0.1:8080", "Address to listen on")
+	account       = flag.String("account", "", "Triton account name to serve")
+	publicKeyPath = flag.String("public-key", "", "Path to the public key used to verify request signatures (authorized_keys or PEM format)")
+	insecure      = flag.Bool("insecure", false, "Accept requests without verifying their signature")
+	readyAfter    = flag.Duration("ready-after", 5*time.Second, "How long new volumes stay in the creating state")
+	deleteAfter   = flag.Duration("delete-after", 2*time.Second, "How long deleted volumes stay in the deleting state")
+	debug         = flag.Bool("debug", false, "Log every request")
+)
+
+func main() {
+	flag.Parse()
+
+	if *debug {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	if *account == "" {
+		logrus.Fatal("account is required")
+	}
+
+	opts := fakecloudapi.Options{
+		Account:     *account,
+		ReadyAfter:  *readyAfter,
+		DeleteAfter: *deleteAfter,
+	}
+
+	if *publicKeyPath != "" {
+		data, err := os.ReadFile(*publicKeyPath)
+		if err != nil {
+			logrus.Fatalf("Failed to read public key: %v", err)
+		}
+		opts.PublicKey, err = fakecloudapi.ParsePublicKey(data)
+		if err != nil {
+			logrus.Fatalf("Failed to parse public key: %v", err)
+		}
+	} else if !*insecure {
+		logrus.Fatal("public-key is required unless --insecure is set")
+	}
+
+	logrus.Infof("Serving fake CloudAPI for account %s on http://%s", *account, *listen)
+	if err := http.ListenAndServe(*listen, fakecloudapi.NewServer(opts)); err != nil {
+		logrus.Fatalf("Failed to serve fake CloudAPI: %v", err)
+	}
+}
@@ -10,14 +10,22 @@ import (
 )
 
 var (
+	mode       = flag.String("mode", driver.ModeAll, "Services to run: controller, node or all. Node mode does not need Triton credentials")
 	endpoint   = flag.String("endpoint", "unix:///var/lib/kubelet/plugins/tritonnfs.csi.triton.com/csi.sock", "CSI endpoint")
 	driverName = flag.String("driver-name", "tritonnfs.csi.triton.com", "Name of the driver")
 	nodeID     = flag.String("node-id", "", "Node ID")
+	clusterID  = flag.String("cluster-id", "", "ID of the Kubernetes cluster, stamped on every volume the driver creates. The controller only manages volumes carrying it")
+	zone       = flag.String("zone", "", "Triton datacenter the plugin runs in, reported as the zone topology segment")
 	version    = flag.Bool("version", false, "Print the version and exit")
 	cloudAPI   = flag.String("cloud-api", "", "Triton CloudAPI endpoint")
 	accountID  = flag.String("account-id", "", "Triton account ID")
 	keyID      = flag.String("key-id", "", "Triton key ID")
 	keyPath    = flag.String("key-path", "", "Path to Triton private key file")
+
+	nodeNetwork = flag.String("node-network", "", "ID of the Triton network the node mounts NFS volumes through, reported as the network topology segment")
+	datacenters = flag.String("datacenters", "", "Comma-separated name=CloudAPI URL pairs of other Triton datacenters to provision volumes in. Requires --zone")
+
+	metricsAddress = flag.String("metrics-address", "", "Address to serve Prometheus metrics on, e.g. :9808. Metrics are disabled when empty")
 )
 
 func main() {
@@ -28,35 +36,44 @@ func main() {
 		os.Exit(0)
 	}
 
-	if *nodeID == "" {
+	if *mode != driver.ModeController && *nodeID == "" {
 		logrus.Fatal("node-id is required")
 	}
 
-	if *cloudAPI == "" {
-		logrus.Fatal("cloud-api endpoint is required")
-	}
+	// Triton credentials are only needed by the controller service
+	if *mode != driver.ModeNode {
+		if *cloudAPI == "" {
+			logrus.Fatal("cloud-api endpoint is required")
+		}
 
-	if *accountID == "" {
-		logrus.Fatal("account-id is required")
-	}
+		if *accountID == "" {
+			logrus.Fatal("account-id is required")
+		}
 
-	if *keyID == "" {
-		logrus.Fatal("key-id is required")
-	}
+		if *keyID == "" {
+			logrus.Fatal("key-id is required")
+		}
 
-	if *keyPath == "" {
-		logrus.Fatal("key-path is required")
+		if *keyPath == "" {
+			logrus.Fatal("key-path is required")
+		}
 	}
 
-	logrus.Infof("Starting TritonNFS CSI driver: %s version: %s", *driverName, driver.DriverVersion)
+	logrus.Infof("Starting TritonNFS CSI driver: %s version: %s mode: %s", *driverName, driver.DriverVersion, *mode)
 
 	drv, err := driver.NewTritonNFSDriver(
+		driver.WithMode(*mode),
 		driver.WithEndpoint(*endpoint),
 		driver.WithNodeID(*nodeID),
+		driver.WithClusterID(*clusterID),
+		driver.WithZone(*zone),
+		driver.WithNodeNetwork(*nodeNetwork),
+		driver.WithDatacenters(*datacenters),
 		driver.WithCloudAPI(*cloudAPI),
 		driver.WithAccountID(*accountID),
 		driver.WithKeyID(*keyID),
 		driver.WithKeyPath(*keyPath),
+		driver.WithMetricsAddress(*metricsAddress),
 	)
 	if err != nil {
 		logrus.Fatalf("Failed to create TritonNFS CSI driver: %v", err)
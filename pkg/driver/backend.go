@@ -0,0 +1,83 @@
+package driver
+
+import (
+	"context"
+)
+
+// Volume states used by Triton
+const (
+	VolumeStateCreating = "creating"
+	VolumeStateReady    = "ready"
+	VolumeStateResizing = "resizing"
+	VolumeStateDeleting = "deleting"
+	VolumeStateFailed   = "failed"
+)
+
+// VolumeFilter selects volumes by name, state and tags. Empty fields match
+// every volume.
+type VolumeFilter struct {
+	// Name is the exact volume name
+	Name string
+
+	// State is the volume state, e.g. VolumeStateReady
+	State string
+
+	// Tags are tags the volume must carry with these values
+	Tags map[string]string
+}
+
+// Matches reports whether a volume is selected by the filter
+func (f *VolumeFilter) Matches(volume *NFSVolume) bool {
+	if f.Name != "" && volume.Name != f.Name {
+		return false
+	}
+	if f.State != "" && volume.State != f.State {
+		return false
+	}
+	for k, v := range f.Tags {
+		if volume.Tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// VolumeBackend is the interface the controller service uses to manage NFS volumes.
+// TritonClient is the CloudAPI-backed implementation; FakeBackend is an
+// in-memory implementation for tests and local development.
+type VolumeBackend interface {
+	// CreateVolume creates a new NFS volume. The returned volume may still be
+	// provisioning, callers should poll GetVolume until it reaches the ready state.
+	CreateVolume(ctx context.Context, req *NFSVolumeRequest) (*NFSVolume, error)
+
+	// GetVolume gets a volume by ID
+	GetVolume(ctx context.Context, id string) (*NFSVolume, error)
+
+	// DeleteVolume deletes a volume by ID
+	DeleteVolume(ctx context.Context, id string) error
+
+	// ExpandVolume starts expanding an existing volume to at least newSize
+	// bytes. The returned volume may still be resizing, callers should poll
+	// GetVolume until it is back in the ready state.
+	ExpandVolume(ctx context.Context, id string, newSize int64) (*NFSVolume, error)
+
+	// SetVolumeTags sets tags on a volume, keeping the other tags it carries
+	SetVolumeTags(ctx context.Context, id string, tags map[string]string) (*NFSVolume, error)
+
+	// ListVolumes lists all NFS volumes. The list may be cached for a few
+	// seconds, use FindVolumes when the result must be current.
+	ListVolumes(ctx context.Context) ([]*NFSVolume, error)
+
+	// FindVolumes lists the current NFS volumes selected by filter
+	FindVolumes(ctx context.Context, filter *VolumeFilter) ([]*NFSVolume, error)
+
+	// ListNetworks lists the networks volumes can be attached to
+	ListNetworks(ctx context.Context) ([]*Network, error)
+
+	// ListVolumeSizes returns the volume sizes in bytes that can be
+	// provisioned, smallest first
+	ListVolumeSizes(ctx context.Context) ([]int64, error)
+}
+
+var _ VolumeBackend = &TritonClient{}
+var _ VolumeBackend = &FakeBackend{}
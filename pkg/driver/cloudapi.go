@@ -0,0 +1,224 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	tritonerrors "github.com/joyent/triton-go/v2/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorClass classifies errors returned by CloudAPI calls
+type ErrorClass string
+
+const (
+	// ErrorClassUnknown is any error that could not be classified
+	ErrorClassUnknown ErrorClass = "unknown"
+
+	// ErrorClassNotFound means the resource does not exist (404, 410)
+	ErrorClassNotFound ErrorClass = "not_found"
+
+	// ErrorClassConflict means the resource is in a conflicting state (409)
+	ErrorClassConflict ErrorClass = "conflict"
+
+	// ErrorClassInvalid means CloudAPI rejected the request arguments (400, 409 InvalidArgument, 422)
+	ErrorClassInvalid ErrorClass = "invalid"
+
+	// ErrorClassThrottled means the request was rate limited (429)
+	ErrorClassThrottled ErrorClass = "throttled"
+
+	// ErrorClassServer means CloudAPI failed to handle the request (5xx)
+	ErrorClassServer ErrorClass = "server"
+
+	// ErrorClassAuth means the credentials were rejected (401, 403)
+	ErrorClassAuth ErrorClass = "auth"
+
+	// ErrorClassNetwork means CloudAPI could not be reached
+	ErrorClassNetwork ErrorClass = "network"
+)
+
+// Retry parameters for transient CloudAPI errors
+const (
+	cloudAPIMaxAttempts    = 5
+	cloudAPIInitialBackoff = 500 * time.Millisecond
+	cloudAPIMaxBackoff     = 10 * time.Second
+)
+
+// CloudAPIError is a classified error from a CloudAPI call
+type CloudAPIError struct {
+	// Op is the name of the CloudAPI operation, e.g. "GetVolume"
+	Op string
+
+	// Class is the error classification
+	Class ErrorClass
+
+	// StatusCode is the HTTP status returned by CloudAPI, or 0 if there was no response
+	StatusCode int
+
+	// Retries is the number of times the call was retried before giving up
+	Retries int
+
+	// Err is the underlying triton-go error
+	Err error
+}
+
+// Error implements the error interface
+func (e *CloudAPIError) Error() string {
+	return fmt.Sprintf("%s failed (%s): %v", e.Op, e.Class, e.Err)
+}
+
+// Unwrap returns the underlying triton-go error
+func (e *CloudAPIError) Unwrap() error {
+	return e.Err
+}
+
+// Transient reports whether the call may succeed if retried
+func (e *CloudAPIError) Transient() bool {
+	switch e.Class {
+	case ErrorClassThrottled, ErrorClassServer, ErrorClassNetwork:
+		return true
+	}
+	return false
+}
+
+// classifyError returns the class and HTTP status code of a CloudAPI error
+func classifyError(err error) (ErrorClass, int) {
+	var cloudErr *CloudAPIError
+	if errors.As(err, &cloudErr) {
+		return cloudErr.Class, cloudErr.StatusCode
+	}
+
+	var apiErr *tritonerrors.APIError
+	if errors.As(err, &apiErr) {
+		return classifyStatus(apiErr.StatusCode, apiErr.Code), apiErr.StatusCode
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorClassUnknown, 0
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrorClassNetwork, 0
+	}
+
+	return ErrorClassUnknown, 0
+}
+
+// classifyStatus classifies a CloudAPI HTTP status and error code
+func classifyStatus(statusCode int, code string) ErrorClass {
+	switch {
+	case statusCode == http.StatusNotFound || statusCode == http.StatusGone:
+		return ErrorClassNotFound
+	case statusCode == http.StatusConflict && code == "InvalidArgument":
+		return ErrorClassInvalid
+	case statusCode == http.StatusConflict:
+		return ErrorClassConflict
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorClassThrottled
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrorClassAuth
+	case statusCode == http.StatusBadRequest || statusCode == http.StatusUnprocessableEntity:
+		return ErrorClassInvalid
+	case statusCode >= 500 && statusCode != http.StatusNotImplemented:
+		return ErrorClassServer
+	}
+	return ErrorClassUnknown
+}
+
+// IsNotFound reports whether err means the CloudAPI resource does not exist
+func IsNotFound(err error) bool {
+	class, _ := classifyError(err)
+	return class == ErrorClassNotFound
+}
+
+// callCloudAPI runs fn, classifying any error it returns. Transient errors are
+// retried with jittered exponential backoff until cloudAPIMaxAttempts is reached
+// or ctx is done. Calls that are not idempotent are only retried when CloudAPI
+// throttled them, since the request was then rejected before being processed.
+func callCloudAPI(ctx context.Context, op string, idempotent bool, fn func(ctx context.Context) error) error {
+	backoff := cloudAPIInitialBackoff
+	start := time.Now()
+
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			observeCloudAPICall(op, start, attempt, nil)
+			return nil
+		}
+
+		class, statusCode := classifyError(err)
+		cloudErr := &CloudAPIError{
+			Op:         op,
+			Class:      class,
+			StatusCode: statusCode,
+			Retries:    attempt,
+			Err:        err,
+		}
+
+		retry := cloudErr.Transient() && (idempotent || class == ErrorClassThrottled)
+		if !retry || attempt+1 >= cloudAPIMaxAttempts || ctx.Err() != nil {
+			observeCloudAPICall(op, start, attempt, cloudErr)
+			return cloudErr
+		}
+
+		// Full jitter keeps concurrent callers from retrying in lockstep
+		delay := time.Duration(rand.Int63n(int64(backoff))) + backoff/2
+		logrus.Warnf("CloudAPI %s failed with %s error (attempt %d/%d), retrying in %v: %v",
+			op, class, attempt+1, cloudAPIMaxAttempts, delay, err)
+
+		select {
+		case <-ctx.Done():
+			observeCloudAPICall(op, start, attempt, cloudErr)
+			return cloudErr
+		case <-time.After(delay):
+		}
+
+		backoff *= 2
+		if backoff > cloudAPIMaxBackoff {
+			backoff = cloudAPIMaxBackoff
+		}
+	}
+}
+
+// cloudAPIStatus converts a backend error into a gRPC status error with a code
+// matching its class. msg describes the failed operation.
+func cloudAPIStatus(err error, msg string) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	code := codes.Internal
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, ErrVolumeSizeNotOffered):
+		code = codes.OutOfRange
+	default:
+		class, _ := classifyError(err)
+		switch class {
+		case ErrorClassNotFound:
+			code = codes.NotFound
+		case ErrorClassConflict:
+			code = codes.Aborted
+		case ErrorClassInvalid:
+			code = codes.InvalidArgument
+		case ErrorClassThrottled, ErrorClassServer, ErrorClassNetwork:
+			code = codes.Unavailable
+		case ErrorClassAuth:
+			code = codes.PermissionDenied
+		}
+	}
+
+	return status.Errorf(code, "%s: %v", msg, err)
+}
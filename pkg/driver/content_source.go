@@ -0,0 +1,120 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// tagContentSource holds the snapshot or volume a volume was populated from,
+// as "snapshot:<ID>" or "volume:<ID>"
+const tagContentSource = "content-source"
+
+// copyCompleteMarker is written to the root of a volume once the copy of its
+// content source has finished. It keeps a controller that restarted from
+// copying the source again over data written to the volume since.
+const copyCompleteMarker = ".tritonnfs-copy-complete"
+
+// Kinds of content sources in the content-source tag
+const (
+	contentSourceSnapshot = "snapshot"
+	contentSourceVolume   = "volume"
+)
+
+// contentSourceTag returns the content-source tag value for a content source,
+// or an empty string when there is none
+func contentSourceTag(source *csi.VolumeContentSource) string {
+	switch {
+	case source.GetSnapshot() != nil:
+		return contentSourceSnapshot + ":" + source.GetSnapshot().GetSnapshotId()
+	case source.GetVolume() != nil:
+		return contentSourceVolume + ":" + source.GetVolume().GetVolumeId()
+	}
+	return ""
+}
+
+// volumeContentSource returns the content source recorded in the tags of a
+// volume and the ID of the Triton volume to copy from, or nil when the volume
+// was not populated from one
+func volumeContentSource(volume *NFSVolume) (*csi.VolumeContentSource, string) {
+	kind, id, ok := strings.Cut(volume.Tags[tagContentSource], ":")
+	if !ok || id == "" {
+		return nil, ""
+	}
+
+	switch kind {
+	case contentSourceSnapshot:
+		return &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: id},
+			},
+		}, id
+	case contentSourceVolume:
+		return &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: id},
+			},
+		}, id
+	}
+	return nil, ""
+}
+
+// checkContentSource checks that the snapshot or volume a new volume is
+// populated from exists and can be copied, and returns the capacity range
+// raised to at least the size of the source
+func (d *TritonNFSDriver) checkContentSource(ctx context.Context, source *csi.VolumeContentSource, capacityRange *csi.CapacityRange) (*csi.CapacityRange, error) {
+	var size int64
+	switch {
+	case source.GetSnapshot() != nil:
+		id := source.GetSnapshot().GetSnapshotId()
+		snapshot, err := d.volumeBackend(ctx).GetVolume(ctx, id)
+		if err != nil {
+			if IsNotFound(err) {
+				return nil, status.Errorf(codes.NotFound, "Snapshot %s not found", id)
+			}
+			return nil, cloudAPIStatus(err, fmt.Sprintf("Failed to get snapshot %s", id))
+		}
+		if !isSnapshotVolume(snapshot) {
+			return nil, status.Errorf(codes.NotFound, "Snapshot %s not found", id)
+		}
+		ready, err := d.snapshotReady(ctx, snapshot, false)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to check snapshot %s: %v", id, err)
+		}
+		if !ready {
+			return nil, status.Errorf(codes.FailedPrecondition, "Snapshot %s is not ready to use", id)
+		}
+		size = snapshot.Size
+
+	case source.GetVolume() != nil:
+		id := source.GetVolume().GetVolumeId()
+		volume, subDirID, err := d.backingVolume(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if volume.State != VolumeStateReady {
+			return nil, status.Errorf(codes.FailedPrecondition, "Source volume %s is in state %s", id, volume.State)
+		}
+		// Subdirectories have no size of their own
+		if subDirID == nil {
+			size = volume.Size
+		}
+
+	default:
+		return nil, status.Error(codes.InvalidArgument, "Volume content source must be a snapshot or a volume")
+	}
+
+	required := capacityRange.GetRequiredBytes()
+	limit := capacityRange.GetLimitBytes()
+	if limit > 0 && limit < size {
+		return nil, status.Errorf(codes.OutOfRange, "Volume content source of %d bytes exceeds limit bytes %d", size, limit)
+	}
+	if required >= size {
+		return capacityRange, nil
+	}
+	return &csi.CapacityRange{RequiredBytes: size, LimitBytes: limit}, nil
+}
@@ -16,8 +16,12 @@ const (
 	// VolumeTypeNFS is the volume type for NFS volumes
 	VolumeTypeNFS = "nfs"
 
+	// TritonVolumeTypeNFS is the Triton volume type used for NFS volumes
+	TritonVolumeTypeNFS = "tritonnfs"
+
 	// Topology Keys
-	TopologyKeyZone = "topology.tritonnfs.csi.triton.com/zone"
+	TopologyKeyZone    = "topology.tritonnfs.csi.triton.com/zone"
+	TopologyKeyNetwork = "topology.tritonnfs.csi.triton.com/network"
 
 	// Default size in bytes (10GB)
 	DefaultVolumeSizeBytes int64 = 10 * 1024 * 1024 * 1024
@@ -32,6 +36,9 @@ var (
 		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
 		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		csi.ControllerServiceCapability_RPC_GET_VOLUME,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
 	}
 )
 
@@ -70,93 +77,217 @@ func (d *TritonNFSDriver) CreateVolume(ctx context.Context, req *csi.CreateVolum
 		}
 	}
 
-	// Get volume size
-	size := DefaultVolumeSizeBytes
-	if req.GetCapacityRange() != nil && req.GetCapacityRange().GetRequiredBytes() > 0 {
-		size = req.GetCapacityRange().GetRequiredBytes()
+	// Validate the NFS mount parameters, and check they agree with the mount
+	// options of the StorageClass
+	mountOptions, err := parseMountParameters(req.GetParameters())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid mount parameters: %v", err)
+	}
+	for _, capability := range volumeCapabilities {
+		options := append(append([]string{}, mountOptions...), capability.GetMount().GetMountFlags()...)
+		if err := checkMountOptions(options); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Mount parameters conflict with mount options: %v", err)
+		}
+	}
+
+	adopt, err := parseAdoptExisting(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the datacenter of the request's parameters, topology or source, and
+	// the Triton account of its secrets
+	datacenter, err := d.volumeDatacenter(req.GetParameters(), req.GetAccessibilityRequirements(), req.GetVolumeContentSource())
+	if err != nil {
+		return nil, err
+	}
+	ctx, err = d.withDatacenter(ctx, req.GetSecrets(), datacenter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject concurrent operations on the same volume name
+	release, err := d.lockOperation(lockPrefixVolumeName + req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Volumes of a StorageClass with a parent volume are subdirectories of it
+	if isSubDirRequest(req.GetParameters()) {
+		if req.GetVolumeContentSource() != nil {
+			return nil, status.Error(codes.InvalidArgument, "Subdirectory volumes cannot be populated from a snapshot or volume")
+		}
+		return d.createSubDirVolume(ctx, req, mountOptions)
+	}
+
+	// Volumes populated from a snapshot or volume must be at least as large as the source
+	capacityRange := req.GetCapacityRange()
+	contentSource := req.GetVolumeContentSource()
+	if contentSource != nil {
+		contentSource, err = d.localContentSource(ctx, contentSource)
+		if err != nil {
+			return nil, err
+		}
+		capacityRange, err = d.checkContentSource(ctx, contentSource, capacityRange)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Get volume size, snapped to a size Triton offers
+	size, err := d.volumeSizeForRange(ctx, capacityRange)
+	if err != nil {
+		return nil, err
 	}
 
 	// Check if volume already exists
-	volumes, err := d.tritonClient.ListVolumes(ctx)
+	volumes, err := d.volumeBackend(ctx).FindVolumes(ctx, &VolumeFilter{Name: req.GetName()})
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to list volumes: %v", err)
-	}
-
-	// Check if a volume with the same name already exists
-	for _, vol := range volumes {
-		if vol.Name == req.GetName() {
-			// Check if the existing volume satisfies the request
-			if vol.Size >= size {
-				// Return the existing volume
-				return &csi.CreateVolumeResponse{
-					Volume: &csi.Volume{
-						VolumeId:      vol.ID,
-						CapacityBytes: vol.Size,
-						VolumeContext: map[string]string{
-							"server":     getVolumeServer(vol),
-							"share":      vol.MountPoint,
-							"type":       VolumeTypeNFS,
-							"volumeName": vol.Name,
-						},
-					},
-				}, nil
-			}
-			// Existing volume doesn't satisfy the request
+		return nil, cloudAPIStatus(err, "Failed to list volumes")
+	}
+
+	// Check if a volume with the same name already exists. It may have been
+	// created by an earlier call that returned while it was still provisioning.
+	vol, err := d.findVolumeByName(ctx, volumes, req.GetName(), adopt)
+	if err != nil {
+		return nil, err
+	}
+	if vol != nil {
+		// Check if the existing volume satisfies the request
+		limit := req.GetCapacityRange().GetLimitBytes()
+		if vol.Size < size || (limit > 0 && vol.Size > limit) {
 			return nil, status.Errorf(codes.AlreadyExists, "Volume with name %s already exists but with different size", req.GetName())
 		}
+		if vol.Tags[tagContentSource] != contentSourceTag(contentSource) {
+			return nil, status.Errorf(codes.AlreadyExists, "Volume with name %s already exists but with a different content source", req.GetName())
+		}
+		return d.createVolumeResponse(ctx, vol, mountOptions, req.GetAccessibilityRequirements())
 	}
 
 	// Create volume request
 	volumeRequest := &NFSVolumeRequest{
 		Name: req.GetName(),
 		Size: size,
-		Type: VolumeTypeNFS,
+		Type: TritonVolumeTypeNFS,
 	}
 
-	// Get parameters from volume context
+	// Resolve the networks of the StorageClass and the requested topology, the
+	// mount network comes first
 	params := req.GetParameters()
-	if params != nil {
-		// Handle networks if provided
-		if networksStr, ok := params["networks"]; ok && networksStr != "" {
-			volumeRequest.Networks = strings.Split(networksStr, ",")
-		}
-
-		// Handle tags if provided
-		volumeRequest.Tags = make(map[string]string)
-		volumeRequest.Tags["created-by"] = "tritonnfs-csi-driver"
-		for k, v := range params {
-			if strings.HasPrefix(k, "tag-") {
-				tagKey := strings.TrimPrefix(k, "tag-")
-				volumeRequest.Tags[tagKey] = v
-			}
+	networks, mountNetworkID, err := d.volumeNetworks(ctx, params, req.GetAccessibilityRequirements())
+	if err != nil {
+		return nil, err
+	}
+	volumeRequest.Networks = networks
+
+	// Handle tags if provided. The ownership tags are applied last so that
+	// StorageClass tags cannot override them.
+	volumeRequest.Tags = make(map[string]string)
+	for k, v := range params {
+		if strings.HasPrefix(k, "tag-") {
+			tagKey := strings.TrimPrefix(k, "tag-")
+			volumeRequest.Tags[tagKey] = v
 		}
 	}
-
-	// Create the volume
-	volume, err := d.tritonClient.CreateVolume(ctx, volumeRequest)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to create volume: %v", err)
+	for k, v := range d.ownershipTags() {
+		volumeRequest.Tags[k] = v
+	}
+	if contentSource != nil {
+		volumeRequest.Tags[tagContentSource] = contentSourceTag(contentSource)
+	}
+	if mountNetworkID != "" {
+		volumeRequest.Tags[tagMountNetwork] = mountNetworkID
 	}
 
-	// Wait for volume to be ready
-	volume, err = waitForVolumeReady(ctx, d.tritonClient, volume.ID)
+	// Create the volume. Triton provisions it asynchronously, so this returns
+	// while the volume is still in the creating state.
+	start, _ := d.provisionStarts.LoadOrStore(req.GetName(), provisionStart{time: time.Now()})
+	volume, err := d.volumeBackend(ctx).CreateVolume(ctx, volumeRequest)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Failed waiting for volume to become ready: %v", err)
+		d.provisionStarts.Delete(req.GetName())
+		return nil, cloudAPIStatus(err, "Failed to create volume")
 	}
+	d.provisionStarts.Store(req.GetName(), provisionStart{time: start.(provisionStart).time, volumeID: volume.ID})
 
-	// Return the created volume
-	return &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      volume.ID,
-			CapacityBytes: volume.Size,
-			VolumeContext: map[string]string{
-				"server":     getVolumeServer(volume),
-				"share":      volume.MountPoint,
-				"type":       VolumeTypeNFS,
-				"volumeName": volume.Name,
+	return d.createVolumeResponse(ctx, volume, mountOptions, req.GetAccessibilityRequirements())
+}
+
+// createVolumeResponse returns the CreateVolume response for a volume that
+// matches the request. While the volume is still provisioning it returns
+// Aborted instead, so the provisioner retries the call and picks the volume
+// up by name once it is ready. A volume that failed to provision is deleted so
+// that the retry creates it again. A volume populated from a snapshot or
+// volume is returned once the data has been copied into it. mountOptions are
+// passed to the node in the volume context, and the accessible topology of the
+// volume uses the topology keys in requirements.
+func (d *TritonNFSDriver) createVolumeResponse(ctx context.Context, volume *NFSVolume, mountOptions []string, requirements *csi.TopologyRequirement) (*csi.CreateVolumeResponse, error) {
+	switch volume.State {
+	case VolumeStateReady:
+		// The nodes could not reach a volume that is not served on its mount network
+		if err := checkMountNetwork(volume); err != nil {
+			return nil, err
+		}
+
+		contentSource, sourceID := volumeContentSource(volume)
+		if contentSource != nil {
+			done, err := d.copyProgress(ctx, volume, sourceID, copyCompleteMarker)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "Failed to copy %s into volume %s: %v", sourceID, volume.Name, err)
+			}
+			if !done {
+				logrus.Infof("Volume %s (%s) is being populated from %s", volume.Name, volume.ID, sourceID)
+				return nil, status.Errorf(codes.Aborted, "Volume %s is still being populated from %s", volume.Name, sourceID)
+			}
+		}
+
+		if start, ok := d.provisionStarts.LoadAndDelete(volume.Name); ok {
+			volumeTimeToReady.Observe(time.Since(start.(provisionStart).time).Seconds())
+		}
+		volumeContext := nfsVolumeContext(volume)
+		if len(mountOptions) > 0 {
+			volumeContext[volumeContextMountOptions] = strings.Join(mountOptions, ",")
+		}
+		return &csi.CreateVolumeResponse{
+			Volume: &csi.Volume{
+				VolumeId:           d.csiVolumeID(ctx, volume.ID),
+				CapacityBytes:      volume.Size,
+				VolumeContext:      volumeContext,
+				ContentSource:      d.csiContentSource(ctx, contentSource),
+				AccessibleTopology: d.accessibleTopology(ctx, volume, requirements),
 			},
-		},
-	}, nil
+		}, nil
+
+	case VolumeStateFailed:
+		logrus.Warnf("Volume %s (%s) failed to provision, deleting it", volume.Name, volume.ID)
+		d.provisionStarts.Delete(volume.Name)
+		if err := d.volumeBackend(ctx).DeleteVolume(ctx, volume.ID); err != nil && !IsNotFound(err) {
+			return nil, cloudAPIStatus(err, fmt.Sprintf("Volume %s failed to provision and could not be deleted", volume.Name))
+		}
+		return nil, status.Errorf(codes.Internal, "Volume %s failed to provision", volume.Name)
+
+	default:
+		logrus.Infof("Volume %s (%s) is in state %s, waiting for it to become ready", volume.Name, volume.ID, volume.State)
+		return nil, status.Errorf(codes.Aborted, "Volume %s is still being provisioned (state %s)", volume.Name, volume.State)
+	}
+}
+
+// provisionStart is when CreateVolume was first called for a volume name, and
+// the ID of the volume once it was created
+type provisionStart struct {
+	time     time.Time
+	volumeID string
+}
+
+// forgetProvisionStart drops the provisioning start of a volume that is
+// deleted before it was returned ready
+func (d *TritonNFSDriver) forgetProvisionStart(volumeID string) {
+	d.provisionStarts.Range(func(name, start interface{}) bool {
+		if start.(provisionStart).volumeID == volumeID {
+			d.provisionStarts.Delete(name)
+		}
+		return true
+	})
 }
 
 // DeleteVolume deletes a volume
@@ -166,15 +297,54 @@ func (d *TritonNFSDriver) DeleteVolume(ctx context.Context, req *csi.DeleteVolum
 		return nil, status.Error(codes.InvalidArgument, "Volume ID must be provided")
 	}
 
+	// Use the datacenter of the volume and the Triton account of the request's secrets
+	ctx, volumeID, err := d.withVolumeDatacenter(ctx, req.GetSecrets(), req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject concurrent operations on the same volume
+	release, err := d.lockOperation(lockPrefixVolumeID + req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Subdirectory volumes are removed from their parent volume
+	subDirID, isSubDir, err := parseSubDirVolumeID(volumeID)
+	if err != nil {
+		logrus.Warnf("Volume ID %s is not valid, assuming it's already deleted: %v", req.GetVolumeId(), err)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	if isSubDir {
+		if err := d.deleteSubDirVolume(ctx, subDirID); err != nil {
+			return nil, err
+		}
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	// Stop copying data into the volume
+	if err := d.volumeCopies.Stop(ctx, volumeID); err != nil {
+		return nil, status.Errorf(codes.Aborted, "Timed out stopping the copy into volume %s", req.GetVolumeId())
+	}
+	d.forgetProvisionStart(volumeID)
+
 	// Delete the volume
-	err := d.tritonClient.DeleteVolume(ctx, req.GetVolumeId())
+	err = d.volumeBackend(ctx).DeleteVolume(ctx, volumeID)
 	if err != nil {
 		// Volume not found is not an error
-		if strings.Contains(err.Error(), "404") {
-			logrus.Warnf("Volume %s not found, assuming it's already deleted", req.GetVolumeId())
+		if IsNotFound(err) {
+			if err := d.checkVolumeAccount(ctx, volumeID, req.GetVolumeId()); err != nil {
+				return nil, err
+			}
+			logrus.Warnf("Volume %s not found in account %s, assuming it's already deleted", req.GetVolumeId(), d.requestAccount(ctx))
 			return &csi.DeleteVolumeResponse{}, nil
 		}
-		return nil, status.Errorf(codes.Internal, "Failed to delete volume: %v", err)
+		// CloudAPI refuses to delete volumes that are still in use
+		if class, _ := classifyError(err); class == ErrorClassConflict {
+			return nil, status.Errorf(codes.FailedPrecondition, "Failed to delete volume: %v", err)
+		}
+		return nil, cloudAPIStatus(err, "Failed to delete volume")
 	}
 
 	return &csi.DeleteVolumeResponse{}, nil
@@ -202,10 +372,23 @@ func (d *TritonNFSDriver) ValidateVolumeCapabilities(ctx context.Context, req *c
 		return nil, status.Error(codes.InvalidArgument, "Volume capabilities must be provided")
 	}
 
+	// Use the datacenter of the volume and the Triton account of the request's secrets
+	ctx, volumeID, err := d.withVolumeDatacenter(ctx, req.GetSecrets(), req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject concurrent operations on the same volume
+	release, err := d.lockOperation(lockPrefixVolumeID + req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	// Check if volume exists
-	_, err := d.tritonClient.GetVolume(ctx, req.GetVolumeId())
+	_, _, err = d.backingVolume(ctx, volumeID)
 	if err != nil {
-		return nil, status.Errorf(codes.NotFound, "Volume with ID %s not found: %v", req.GetVolumeId(), err)
+		return nil, err
 	}
 
 	// Check if volume capabilities are supported
@@ -228,36 +411,52 @@ func (d *TritonNFSDriver) ValidateVolumeCapabilities(ctx context.Context, req *c
 
 // ListVolumes lists all volumes
 func (d *TritonNFSDriver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
-	// Pagination support isn't implemented yet
-	if req.GetStartingToken() != "" {
-		return nil, status.Error(codes.Unimplemented, "Pagination is not implemented")
+	// List the volumes of every datacenter under their CSI IDs
+	var owned, listed []*NFSVolume
+	for _, datacenter := range d.datacenterNames() {
+		ctx, err := d.withDatacenter(ctx, nil, datacenter)
+		if err != nil {
+			return nil, err
+		}
+		volumes, err := d.volumeBackend(ctx).ListVolumes(ctx)
+		if err != nil {
+			return nil, cloudAPIStatus(err, "Failed to list volumes")
+		}
+
+		// Snapshots are listed by ListSnapshots, and parent volumes of
+		// subdirectory volumes are not PVs
+		for _, vol := range d.ownedVolumes(volumes) {
+			owned = append(owned, vol)
+			if !isSnapshotVolume(vol) && !isSubDirParentVolume(vol) {
+				listed = append(listed, d.withCSIVolumeID(ctx, vol))
+			}
+		}
 	}
+	observeVolumeStates(owned)
 
-	// List volumes
-	volumes, err := d.tritonClient.ListVolumes(ctx)
+	page, nextToken, err := paginateVolumes(listed, req.GetMaxEntries(), req.GetStartingToken())
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to list volumes: %v", err)
+		return nil, err
 	}
 
 	// Build response
 	var entries []*csi.ListVolumesResponse_Entry
-	for _, vol := range volumes {
+	for _, vol := range page {
 		entries = append(entries, &csi.ListVolumesResponse_Entry{
 			Volume: &csi.Volume{
 				VolumeId:      vol.ID,
 				CapacityBytes: vol.Size,
-				VolumeContext: map[string]string{
-					"server":     getVolumeServer(vol),
-					"share":      vol.MountPoint,
-					"type":       VolumeTypeNFS,
-					"volumeName": vol.Name,
-				},
+				VolumeContext: nfsVolumeContext(vol),
+			},
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				VolumeCondition: volumeCondition(vol),
 			},
 		})
 	}
 
 	return &csi.ListVolumesResponse{
-		Entries: entries,
+		Entries:   entries,
+		NextToken: nextToken,
 	}, nil
 }
 
@@ -284,13 +483,45 @@ func (d *TritonNFSDriver) ControllerExpandVolume(ctx context.Context, req *csi.C
 	if requiredBytes <= 0 {
 		return nil, status.Error(codes.InvalidArgument, "Required bytes must be greater than 0")
 	}
-	
+
+	// Use the datacenter of the volume and the Triton account of the request's secrets
+	ctx, volumeID, err := d.withVolumeDatacenter(ctx, req.GetSecrets(), req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject concurrent operations on the same volume
+	release, err := d.lockOperation(lockPrefixVolumeID + req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	// Get the current volume
-	volume, err := d.tritonClient.GetVolume(ctx, req.GetVolumeId())
+	volume, subDirID, err := d.backingVolume(ctx, volumeID)
 	if err != nil {
-		return nil, status.Errorf(codes.NotFound, "Volume with ID %s not found: %v", req.GetVolumeId(), err)
+		return nil, err
+	}
+
+	// Subdirectories have no quota of their own, so they grow with their parent
+	if subDirID != nil {
+		return &csi.ControllerExpandVolumeResponse{
+			CapacityBytes:         requiredBytes,
+			NodeExpansionRequired: false,
+		}, nil
 	}
 	
+	// Triton can only resize a volume that is not busy with another operation.
+	// A resize started by an earlier call is finished once the volume is ready.
+	switch volume.State {
+	case VolumeStateReady:
+	case VolumeStateFailed:
+		return nil, status.Errorf(codes.FailedPrecondition, "Volume %s is in state %s and cannot be expanded", req.GetVolumeId(), volume.State)
+	default:
+		logrus.Infof("Volume %s is in state %s, waiting for it to become ready", req.GetVolumeId(), volume.State)
+		return nil, status.Errorf(codes.Aborted, "Volume %s is still being resized or provisioned (state %s)", req.GetVolumeId(), volume.State)
+	}
+
 	// Check if resizing is needed
 	if volume.Size >= requiredBytes {
 		// Volume is already larger than the requested size, no resizing needed
@@ -299,11 +530,22 @@ func (d *TritonNFSDriver) ControllerExpandVolume(ctx context.Context, req *csi.C
 			NodeExpansionRequired: false, // NFS volumes do not require node expansion
 		}, nil
 	}
-	
-	// Expand the volume
-	expandedVolume, err := d.tritonClient.ExpandVolume(ctx, req.GetVolumeId(), requiredBytes)
+
+	// Snap the new size to a size Triton offers
+	newSize, err := d.volumeSizeForRange(ctx, req.GetCapacityRange())
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to expand volume: %v", err)
+		return nil, err
+	}
+
+	// Start expanding the volume. Triton resizes it asynchronously, so the
+	// resizer retries the call until the volume is ready with the new size.
+	expandedVolume, err := d.volumeBackend(ctx).ExpandVolume(ctx, volumeID, newSize)
+	if err != nil {
+		return nil, cloudAPIStatus(err, "Failed to expand volume")
+	}
+	if expandedVolume.State != VolumeStateReady || expandedVolume.Size < requiredBytes {
+		logrus.Infof("Volume %s is being resized to %d bytes", req.GetVolumeId(), newSize)
+		return nil, status.Errorf(codes.Aborted, "Volume %s is still being resized to %d bytes", req.GetVolumeId(), newSize)
 	}
 	
 	// Return the new size
@@ -313,24 +555,6 @@ func (d *TritonNFSDriver) ControllerExpandVolume(ctx context.Context, req *csi.C
 	}, nil
 }
 
-// CreateSnapshot creates a snapshot
-func (d *TritonNFSDriver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	// Not implemented yet
-	return nil, status.Error(codes.Unimplemented, "CreateSnapshot is not implemented")
-}
-
-// DeleteSnapshot deletes a snapshot
-func (d *TritonNFSDriver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	// Not implemented yet
-	return nil, status.Error(codes.Unimplemented, "DeleteSnapshot is not implemented")
-}
-
-// ListSnapshots lists all snapshots
-func (d *TritonNFSDriver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
-	// Not implemented yet
-	return nil, status.Error(codes.Unimplemented, "ListSnapshots is not implemented")
-}
-
 // ControllerGetVolume gets volume info
 func (d *TritonNFSDriver) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
 	// Validate arguments
@@ -338,23 +562,44 @@ func (d *TritonNFSDriver) ControllerGetVolume(ctx context.Context, req *csi.Cont
 		return nil, status.Error(codes.InvalidArgument, "Volume ID must be provided")
 	}
 
-	// Get volume
-	volume, err := d.tritonClient.GetVolume(ctx, req.GetVolumeId())
+	// Get volume from its datacenter
+	ctx, localID, err := d.withVolumeDatacenter(ctx, nil, req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject concurrent operations on the same volume
+	release, err := d.lockOperation(lockPrefixVolumeID + req.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	volume, subDirID, err := d.backingVolume(ctx, localID)
 	if err != nil {
-		return nil, status.Errorf(codes.NotFound, "Volume with ID %s not found: %v", req.GetVolumeId(), err)
+		return nil, err
+	}
+
+	// Subdirectory volumes report the share of their parent, and no capacity
+	// since they have no quota
+	volumeID := d.csiVolumeID(ctx, volume.ID)
+	capacity := volume.Size
+	volumeContext := nfsVolumeContext(volume)
+	if subDirID != nil {
+		volumeID = req.GetVolumeId()
+		capacity = 0
+		volumeContext[volumeContextSubDir] = subDirID.SubDir
 	}
 
 	// Build response
 	return &csi.ControllerGetVolumeResponse{
 		Volume: &csi.Volume{
-			VolumeId:      volume.ID,
-			CapacityBytes: volume.Size,
-			VolumeContext: map[string]string{
-				"server":     getVolumeServer(volume),
-				"share":      volume.MountPoint,
-				"type":       VolumeTypeNFS,
-				"volumeName": volume.Name,
-			},
+			VolumeId:      volumeID,
+			CapacityBytes: capacity,
+			VolumeContext: volumeContext,
+		},
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			VolumeCondition: volumeCondition(volume),
 		},
 	}, nil
 }
@@ -365,60 +610,73 @@ func (d *TritonNFSDriver) ControllerModifyVolume(ctx context.Context, req *csi.C
 	return nil, status.Error(codes.Unimplemented, "ControllerModifyVolume is not implemented")
 }
 
-// Helper function to get the NFS server IP from the volume
-func getVolumeServer(volume *NFSVolume) string {
-	// Extract IP from FileSystemPath
-	if volume.FileSystemPath != "" {
-		// FileSystemPath format is expected to be: "ip_address:/exports/path"
-		parts := strings.Split(volume.FileSystemPath, ":")
-		if len(parts) > 0 {
-			return parts[0]
-		}
+// volumeSizeForRange returns the smallest volume size offered by the backend
+// that satisfies the capacity range. When no size is required, the default
+// size is used if the limit allows it.
+func (d *TritonNFSDriver) volumeSizeForRange(ctx context.Context, capacityRange *csi.CapacityRange) (int64, error) {
+	required := capacityRange.GetRequiredBytes()
+	limit := capacityRange.GetLimitBytes()
+	if limit > 0 && required > limit {
+		return 0, status.Errorf(codes.InvalidArgument, "Required bytes %d exceed limit bytes %d", required, limit)
+	}
+	if required == 0 && (limit == 0 || limit >= DefaultVolumeSizeBytes) {
+		required = DefaultVolumeSizeBytes
 	}
-	
-	// Log a warning if no filesystem path is found
-	logrus.Warnf("No filesystem_path found for volume %s, unable to determine NFS server IP", volume.ID)
-	return ""
-}
 
-// waitForVolumeReady waits for a volume to become ready
-func waitForVolumeReady(ctx context.Context, client *TritonClient, volumeID string) (*NFSVolume, error) {
-	// Maximum number of retries
-	maxRetries := 30
-	// Retry interval in seconds
-	retryInterval := 5
-
-	for i := 0; i < maxRetries; i++ {
-		// Check if context is cancelled
-		select {
-		case <-ctx.Done():
-			return nil, fmt.Errorf("context cancelled while waiting for volume to be ready")
-		default:
-		}
+	sizes, err := d.volumeBackend(ctx).ListVolumeSizes(ctx)
+	if err != nil {
+		return 0, cloudAPIStatus(err, "Failed to list volume sizes")
+	}
 
-		// Get volume status
-		volume, err := client.GetVolume(ctx, volumeID)
-		if err != nil {
-			logrus.Errorf("Failed to get volume status: %v", err)
-			return nil, err
-		}
+	size, err := selectVolumeSize(sizes, required, limit)
+	if err != nil {
+		return 0, status.Error(codes.OutOfRange, err.Error())
+	}
+	return size, nil
+}
 
-		// Check if volume is ready
-		if volume.State == "ready" {
-			return volume, nil
+// volumeCondition reports the health of a volume from its Triton state
+func volumeCondition(volume *NFSVolume) *csi.VolumeCondition {
+	switch volume.State {
+	case VolumeStateReady, VolumeStateResizing:
+		return &csi.VolumeCondition{
+			Abnormal: false,
+			Message:  fmt.Sprintf("Triton volume is %s", volume.State),
 		}
-
-		// If volume is in error state, return error
-		if volume.State == "error" {
-			return nil, fmt.Errorf("volume is in error state")
+	case VolumeStateFailed:
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  "Triton volume is in the failed state",
 		}
+	default:
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("Triton volume is %s and cannot be used", volume.State),
+		}
+	}
+}
 
-		// Log current state
-		logrus.Infof("Volume %s is in state %s, waiting %d seconds...", volumeID, volume.State, retryInterval)
+// nfsVolumeContext returns the volume context the node needs to mount a volume:
+// the NFS server address, the exported share, the volume type and name
+func nfsVolumeContext(volume *NFSVolume) map[string]string {
+	volumeContext := map[string]string{
+		"server":     "",
+		"share":      "",
+		"type":       VolumeTypeNFS,
+		"volumeName": volume.Name,
+	}
 
-		// Wait before retrying
-		time.Sleep(time.Duration(retryInterval) * time.Second)
+	if volume.FileSystemPath == "" {
+		logrus.Warnf("No filesystem_path found for volume %s, unable to determine NFS server", volume.ID)
+		return volumeContext
 	}
 
-	return nil, fmt.Errorf("timed out waiting for volume to be ready")
-}
\ No newline at end of file
+	nfsPath, err := volumeNFSPath(volume)
+	if err != nil {
+		logrus.Warnf("Unable to determine NFS server of volume %s: %v", volume.ID, err)
+		return volumeContext
+	}
+	volumeContext["server"] = nfsPath.Server()
+	volumeContext["share"] = nfsPath.Share
+	return volumeContext
+}
@@ -0,0 +1,354 @@
+package driver
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	tritonerrors "github.com/joyent/triton-go/v2/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const gib = 1024 * 1024 * 1024
+
+// newTestController returns a controller driver backed by a FakeBackend
+func newTestController(t *testing.T) (*TritonNFSDriver, *FakeBackend) {
+	t.Helper()
+	backend := NewFakeBackend()
+	d, err := NewTritonNFSDriver(WithMode(ModeController), WithVolumeBackend(backend))
+	if err != nil {
+		t.Fatalf("NewTritonNFSDriver: %v", err)
+	}
+	return d, backend
+}
+
+func createVolumeRequest(name string, requiredBytes int64) *csi.CreateVolumeRequest {
+	return &csi.CreateVolumeRequest{
+		Name:          name,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: requiredBytes},
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		}},
+	}
+}
+
+func expectCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Fatalf("expected %s, got %v", code, err)
+	}
+}
+
+// expectNoProvisionStarts checks that no volume is recorded as provisioning
+func expectNoProvisionStarts(t *testing.T, d *TritonNFSDriver) {
+	t.Helper()
+	d.provisionStarts.Range(func(name, start interface{}) bool {
+		t.Errorf("expected no provisioning start, got %v for %v", start, name)
+		return true
+	})
+}
+
+func TestCreateVolume(t *testing.T) {
+	d, backend := newTestController(t)
+	ctx := context.Background()
+
+	resp, err := d.CreateVolume(ctx, createVolumeRequest("pvc-1", 15*gib))
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	volume := resp.GetVolume()
+	if volume.GetCapacityBytes() != 20*gib {
+		t.Errorf("expected the 15 GiB request to be rounded up to 20 GiB, got %d bytes", volume.GetCapacityBytes())
+	}
+	if server := volume.GetVolumeContext()["server"]; server != FakeBackendServer {
+		t.Errorf("expected server %s, got %q", FakeBackendServer, server)
+	}
+	if share := volume.GetVolumeContext()["share"]; share != "/exports/"+volume.GetVolumeId() {
+		t.Errorf("expected the share of volume %s, got %q", volume.GetVolumeId(), share)
+	}
+
+	stored, err := backend.GetVolume(ctx, volume.GetVolumeId())
+	if err != nil {
+		t.Fatalf("GetVolume: %v", err)
+	}
+	if !d.ownsVolume(stored) {
+		t.Errorf("expected the volume to carry the ownership tags, got %v", stored.Tags)
+	}
+
+	// A retry returns the same volume
+	again, err := d.CreateVolume(ctx, createVolumeRequest("pvc-1", 15*gib))
+	if err != nil {
+		t.Fatalf("CreateVolume retry: %v", err)
+	}
+	if again.GetVolume().GetVolumeId() != volume.GetVolumeId() {
+		t.Errorf("expected the retry to return volume %s, got %s", volume.GetVolumeId(), again.GetVolume().GetVolumeId())
+	}
+
+	// A retry asking for a larger volume conflicts with it
+	_, err = d.CreateVolume(ctx, createVolumeRequest("pvc-1", 50*gib))
+	expectCode(t, err, codes.AlreadyExists)
+}
+
+func TestCreateVolumeProvisioning(t *testing.T) {
+	d, backend := newTestController(t)
+	ctx := context.Background()
+	backend.SetReadyAfter(time.Hour)
+
+	_, err := d.CreateVolume(ctx, createVolumeRequest("pvc-1", 0))
+	expectCode(t, err, codes.Aborted)
+
+	volumes, err := backend.ListVolumes(ctx)
+	if err != nil || len(volumes) != 1 {
+		t.Fatalf("expected one volume being provisioned, got %v (%v)", volumes, err)
+	}
+	if err := backend.SetVolumeState(volumes[0].ID, VolumeStateReady); err != nil {
+		t.Fatalf("SetVolumeState: %v", err)
+	}
+
+	resp, err := d.CreateVolume(ctx, createVolumeRequest("pvc-1", 0))
+	if err != nil {
+		t.Fatalf("CreateVolume once ready: %v", err)
+	}
+	if resp.GetVolume().GetVolumeId() != volumes[0].ID {
+		t.Errorf("expected volume %s, got %s", volumes[0].ID, resp.GetVolume().GetVolumeId())
+	}
+	if resp.GetVolume().GetCapacityBytes() != DefaultVolumeSizeBytes {
+		t.Errorf("expected the default size, got %d bytes", resp.GetVolume().GetCapacityBytes())
+	}
+}
+
+func TestCreateVolumeFailed(t *testing.T) {
+	d, backend := newTestController(t)
+	ctx := context.Background()
+	backend.FailVolume("pvc-1")
+
+	_, err := d.CreateVolume(ctx, createVolumeRequest("pvc-1", 0))
+	expectCode(t, err, codes.Internal)
+
+	volumes, err := backend.ListVolumes(ctx)
+	if err != nil {
+		t.Fatalf("ListVolumes: %v", err)
+	}
+	if len(volumes) != 0 {
+		t.Errorf("expected the failed volume to be deleted, got %d volumes", len(volumes))
+	}
+	expectNoProvisionStarts(t, d)
+
+	// A volume that cannot be created is not recorded as provisioning
+	backend.InjectError(FakeOpCreateVolume, &tritonerrors.APIError{StatusCode: http.StatusConflict, Code: "InvalidArgument"})
+	_, err = d.CreateVolume(ctx, createVolumeRequest("pvc-2", 0))
+	if err == nil {
+		t.Fatal("expected CreateVolume to fail")
+	}
+	expectNoProvisionStarts(t, d)
+}
+
+func TestDeleteVolumeProvisioning(t *testing.T) {
+	d, backend := newTestController(t)
+	ctx := context.Background()
+	backend.SetReadyAfter(time.Hour)
+
+	_, err := d.CreateVolume(ctx, createVolumeRequest("pvc-1", 0))
+	expectCode(t, err, codes.Aborted)
+	volumes, err := backend.ListVolumes(ctx)
+	if err != nil || len(volumes) != 1 {
+		t.Fatalf("expected one volume being provisioned, got %v (%v)", volumes, err)
+	}
+
+	// A volume deleted while it is provisioning is no longer recorded
+	if _, err := d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumes[0].ID}); err != nil {
+		t.Fatalf("DeleteVolume: %v", err)
+	}
+	expectNoProvisionStarts(t, d)
+}
+
+func TestDeleteVolume(t *testing.T) {
+	d, backend := newTestController(t)
+	ctx := context.Background()
+
+	resp, err := d.CreateVolume(ctx, createVolumeRequest("pvc-1", 0))
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	volumeID := resp.GetVolume().GetVolumeId()
+
+	// CloudAPI errors are returned, and the volume is kept
+	backend.InjectError(FakeOpDeleteVolume, &tritonerrors.APIError{StatusCode: http.StatusServiceUnavailable, Code: "ServiceUnavailable"})
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+	expectCode(t, err, codes.Unavailable)
+	if _, err := backend.GetVolume(ctx, volumeID); err != nil {
+		t.Fatalf("expected volume %s to be kept: %v", volumeID, err)
+	}
+	backend.InjectError(FakeOpDeleteVolume, nil)
+
+	if _, err := d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+		t.Fatalf("DeleteVolume: %v", err)
+	}
+	if _, err := backend.GetVolume(ctx, volumeID); !IsNotFound(err) {
+		t.Errorf("expected volume %s to be deleted, got %v", volumeID, err)
+	}
+
+	// Deleting a volume that is gone succeeds
+	if _, err := d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+		t.Errorf("DeleteVolume of a deleted volume: %v", err)
+	}
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{})
+	expectCode(t, err, codes.InvalidArgument)
+}
+
+func TestControllerExpandVolume(t *testing.T) {
+	d, backend := newTestController(t)
+	ctx := context.Background()
+
+	resp, err := d.CreateVolume(ctx, createVolumeRequest("pvc-1", 0))
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	volumeID := resp.GetVolume().GetVolumeId()
+	expand := func(requiredBytes int64) (*csi.ControllerExpandVolumeResponse, error) {
+		return d.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+			VolumeId:      volumeID,
+			CapacityRange: &csi.CapacityRange{RequiredBytes: requiredBytes},
+		})
+	}
+
+	// A volume that is large enough is not resized
+	expanded, err := expand(5 * gib)
+	if err != nil {
+		t.Fatalf("ControllerExpandVolume: %v", err)
+	}
+	if expanded.GetCapacityBytes() != DefaultVolumeSizeBytes {
+		t.Errorf("expected %d bytes, got %d", DefaultVolumeSizeBytes, expanded.GetCapacityBytes())
+	}
+
+	// The resize is retried with Aborted until the volume is ready
+	backend.SetReadyAfter(time.Hour)
+	_, err = expand(15 * gib)
+	expectCode(t, err, codes.Aborted)
+	_, err = expand(15 * gib)
+	expectCode(t, err, codes.Aborted)
+
+	if err := backend.SetVolumeState(volumeID, VolumeStateReady); err != nil {
+		t.Fatalf("SetVolumeState: %v", err)
+	}
+	expanded, err = expand(15 * gib)
+	if err != nil {
+		t.Fatalf("ControllerExpandVolume once resized: %v", err)
+	}
+	if expanded.GetCapacityBytes() != 20*gib {
+		t.Errorf("expected the volume to be resized to 20 GiB, got %d bytes", expanded.GetCapacityBytes())
+	}
+
+	// Sizes beyond the catalogue are out of range
+	_, err = expand(2000 * gib)
+	expectCode(t, err, codes.OutOfRange)
+
+	// Failed volumes cannot be expanded
+	if err := backend.SetVolumeState(volumeID, VolumeStateFailed); err != nil {
+		t.Fatalf("SetVolumeState: %v", err)
+	}
+	_, err = expand(30 * gib)
+	expectCode(t, err, codes.FailedPrecondition)
+
+	_, err = d.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      "00000000-0000-0000-0000-000000000000",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: gib},
+	})
+	expectCode(t, err, codes.NotFound)
+}
+
+func TestCreateVolumeMountNetwork(t *testing.T) {
+	d, backend := newTestController(t)
+	ctx := context.Background()
+
+	req := createVolumeRequest("pvc-1", 0)
+	req.Parameters = map[string]string{paramMountNetwork: FakeBackendNetwork.Name}
+	resp, err := d.CreateVolume(ctx, req)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	if server := resp.GetVolume().GetVolumeContext()["server"]; server != FakeBackendServer {
+		t.Errorf("expected server %s, got %q", FakeBackendServer, server)
+	}
+
+	// A mount network that is not listed is rejected before creating a volume
+	req = createVolumeRequest("pvc-2", 0)
+	req.Parameters = map[string]string{paramMountNetwork: "00000000-0000-0000-0000-000000000000"}
+	_, err = d.CreateVolume(ctx, req)
+	expectCode(t, err, codes.InvalidArgument)
+	if volumes, err := backend.ListVolumes(ctx); err != nil || len(volumes) != 1 {
+		t.Errorf("expected no other volume to be created, got %v (%v)", volumes, err)
+	}
+}
+
+func TestCreateVolumeAdoptExisting(t *testing.T) {
+	d, backend := newTestController(t)
+	ctx := context.Background()
+
+	// A volume made by hand is not reused unless it is adopted
+	existing, err := backend.CreateVolume(ctx, &NFSVolumeRequest{Name: "pvc-1", Size: DefaultVolumeSizeBytes, Type: TritonVolumeTypeNFS, Tags: map[string]string{"team": "a"}})
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	_, err = d.CreateVolume(ctx, createVolumeRequest("pvc-1", 0))
+	expectCode(t, err, codes.AlreadyExists)
+
+	req := createVolumeRequest("pvc-1", 0)
+	req.Parameters = map[string]string{paramAdoptExisting: "true"}
+	resp, err := d.CreateVolume(ctx, req)
+	if err != nil {
+		t.Fatalf("CreateVolume adopting the volume: %v", err)
+	}
+	if resp.GetVolume().GetVolumeId() != existing.ID {
+		t.Errorf("expected volume %s to be adopted, got %s", existing.ID, resp.GetVolume().GetVolumeId())
+	}
+
+	// The adopted volume is owned from then on, and keeps its other tags
+	adopted, err := backend.GetVolume(ctx, existing.ID)
+	if err != nil {
+		t.Fatalf("GetVolume: %v", err)
+	}
+	if !d.ownsVolume(adopted) || adopted.Tags["team"] != "a" {
+		t.Errorf("expected the ownership tags next to the existing ones, got %v", adopted.Tags)
+	}
+	listed, err := d.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatalf("ListVolumes: %v", err)
+	}
+	if len(listed.GetEntries()) != 1 || listed.GetEntries()[0].GetVolume().GetVolumeId() != existing.ID {
+		t.Errorf("expected ListVolumes to return the adopted volume, got %v", listed.GetEntries())
+	}
+}
+
+func TestVolumeOperationsAreSerialized(t *testing.T) {
+	d, _ := newTestController(t)
+	ctx := context.Background()
+
+	resp, err := d.CreateVolume(ctx, createVolumeRequest("pvc-1", 0))
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	volumeID := resp.GetVolume().GetVolumeId()
+	capabilities := createVolumeRequest("pvc-1", 0).GetVolumeCapabilities()
+
+	// Every volume RPC is rejected while another operation holds the volume
+	release, err := d.lockOperation(lockPrefixVolumeID + volumeID)
+	if err != nil {
+		t.Fatalf("lockOperation: %v", err)
+	}
+	_, err = d.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{VolumeId: volumeID, VolumeCapabilities: capabilities})
+	expectCode(t, err, codes.Aborted)
+	_, err = d.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: volumeID})
+	expectCode(t, err, codes.Aborted)
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+	expectCode(t, err, codes.Aborted)
+	release()
+
+	if _, err := d.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{VolumeId: volumeID, VolumeCapabilities: capabilities}); err != nil {
+		t.Errorf("ValidateVolumeCapabilities: %v", err)
+	}
+}
@@ -0,0 +1,357 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// tagCopyComplete is set on a volume once the copy into it has finished, to
+// the time it finished in RFC 3339 format. Unlike the completion marker, it is
+// seen without mounting the volume.
+const tagCopyComplete = "copy-complete"
+
+// volumeCopy tracks the copy of a source volume into a destination volume
+type volumeCopy struct {
+	done   chan struct{}
+	err    error
+	cancel context.CancelFunc
+}
+
+// Result reports whether the copy has finished, and the error it failed with
+func (c *volumeCopy) Result() (bool, error) {
+	select {
+	case <-c.done:
+		return true, c.err
+	default:
+		return false, nil
+	}
+}
+
+// volumeCopies tracks the copies started by this controller by destination
+// volume ID, and the destinations known to be complete
+type volumeCopies struct {
+	mu     sync.Mutex
+	copies map[string]*volumeCopy
+}
+
+// newVolumeCopies creates an empty copy tracker
+func newVolumeCopies() *volumeCopies {
+	return &volumeCopies{
+		copies: make(map[string]*volumeCopy),
+	}
+}
+
+// Get returns the copy into a volume ID, or nil if none was started
+func (s *volumeCopies) Get(id string) *volumeCopy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.copies[id]
+}
+
+// Start registers a copy into a volume ID that is stopped with cancel, and
+// returns it unless a copy is already registered
+func (s *volumeCopies) Start(id string, cancel context.CancelFunc) (*volumeCopy, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.copies[id]; ok {
+		return c, false
+	}
+	c := &volumeCopy{done: make(chan struct{}), cancel: cancel}
+	s.copies[id] = c
+	return c, true
+}
+
+// Completed reports whether the copy into a volume ID is known to have
+// finished successfully
+func (s *volumeCopies) Completed(id string) bool {
+	c := s.Get(id)
+	if c == nil {
+		return false
+	}
+	done, err := c.Result()
+	return done && err == nil
+}
+
+// Complete records a volume ID as complete without running a copy
+func (s *volumeCopies) Complete(id string) {
+	c := &volumeCopy{done: make(chan struct{}), cancel: func() {}}
+	close(c.done)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.copies[id] = c
+}
+
+// Remove forgets the copy into a volume ID
+func (s *volumeCopies) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.copies, id)
+}
+
+// Stop cancels a running copy into a volume ID and waits for it to exit
+func (s *volumeCopies) Stop(ctx context.Context, id string) error {
+	c := s.Get(id)
+	if c == nil {
+		return nil
+	}
+	c.cancel()
+	select {
+	case <-c.done:
+		s.Remove(id)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// copyComplete reports whether the copy into a volume is known to have
+// finished, from its tag or the record of this controller
+func (d *TritonNFSDriver) copyComplete(volume *NFSVolume) bool {
+	return volume.Tags[tagCopyComplete] != "" || d.volumeCopies.Completed(volume.ID)
+}
+
+// copyProgress reports whether the copy of sourceID into dest has finished,
+// starting it if it is not running. A copy this controller has no record of
+// is complete when dest carries the completion tag, or holds the completion
+// marker of a copy that could not be tagged, since it may have been run by a
+// previous controller. A failed copy is reported once and started again by
+// the next call.
+func (d *TritonNFSDriver) copyProgress(ctx context.Context, dest *NFSVolume, sourceID, marker string) (bool, error) {
+	if dest.Tags[tagCopyComplete] != "" {
+		return true, nil
+	}
+	c := d.volumeCopies.Get(dest.ID)
+	if c == nil {
+		complete, err := d.hasCopyMarker(dest, marker)
+		if err != nil {
+			return false, err
+		}
+		if complete {
+			d.volumeCopies.Complete(dest.ID)
+			d.markCopyComplete(ctx, dest)
+			return true, nil
+		}
+		d.startVolumeCopy(ctx, dest, sourceID, marker)
+		return false, nil
+	}
+
+	done, err := c.Result()
+	if err != nil {
+		d.volumeCopies.Remove(dest.ID)
+	}
+	return done && err == nil, err
+}
+
+// markCopyComplete tags a volume whose copy has finished, so that it is seen
+// complete without mounting it. A volume that cannot be tagged is still found
+// complete through its marker.
+func (d *TritonNFSDriver) markCopyComplete(ctx context.Context, dest *NFSVolume) {
+	tags := map[string]string{tagCopyComplete: time.Now().UTC().Format(time.RFC3339)}
+	if _, err := d.volumeBackend(ctx).SetVolumeTags(ctx, dest.ID, tags); err != nil {
+		logrus.Warnf("Failed to tag volume %s as complete: %v", dest.Name, err)
+	}
+}
+
+// hasCopyMarker reports whether the completion marker of a copy is in the
+// root of dest, which is mounted on the controller to look for it
+func (d *TritonNFSDriver) hasCopyMarker(dest *NFSVolume, marker string) (bool, error) {
+	var complete bool
+	err := d.withVolumeMounted(dest, nil, func(dir string) error {
+		_, err := os.Stat(filepath.Join(dir, marker))
+		if err == nil {
+			complete = true
+			return nil
+		}
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	})
+	return complete, err
+}
+
+// startVolumeCopy copies the volume with ID sourceID into dest in the
+// background. Both volumes are mounted on the controller. Once the copy has
+// finished, the marker file is written to the root of dest and dest is tagged
+// complete. The copy
+// outlives the request of ctx, but uses the same backend.
+func (d *TritonNFSDriver) startVolumeCopy(ctx context.Context, dest *NFSVolume, sourceID, marker string) {
+	ctx, cancel := context.WithCancel(withBackend(context.Background(), d.requestDatacenter(ctx), d.requestAccount(ctx), d.volumeBackend(ctx)))
+	c, started := d.volumeCopies.Start(dest.ID, cancel)
+	if !started {
+		cancel()
+		return
+	}
+
+	go func() {
+		defer close(c.done)
+		defer cancel()
+
+		logrus.Infof("Copying volume %s into volume %s (%s)", sourceID, dest.Name, dest.ID)
+		start := time.Now()
+
+		source, subDirID, err := d.backingVolume(ctx, sourceID)
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		c.err = d.withVolumeMounted(source, nil, func(sourceDir string) error {
+			if subDirID != nil {
+				sourceDir = filepath.Join(sourceDir, subDirID.SubDir)
+			}
+			return d.withVolumeMounted(dest, nil, func(destDir string) error {
+				if err := copyTree(ctx, sourceDir, destDir); err != nil {
+					return err
+				}
+				content := time.Now().UTC().Format(time.RFC3339) + "\n"
+				if err := os.WriteFile(filepath.Join(destDir, marker), []byte(content), 0644); err != nil {
+					return fmt.Errorf("failed to write %s: %v", marker, err)
+				}
+				return nil
+			})
+		})
+		if c.err != nil {
+			logrus.Errorf("Failed to copy volume %s into volume %s: %v", sourceID, dest.Name, c.err)
+			return
+		}
+		logrus.Infof("Copied volume %s into volume %s (%s) in %s", sourceID, dest.Name, dest.ID, time.Since(start).Round(time.Second))
+		d.markCopyComplete(ctx, dest)
+	}()
+}
+
+// copyTree copies the contents of src into dst like rsync -a: directories,
+// regular files and symlinks keep their ownership, permissions and
+// timestamps, and files that exist in dst are overwritten, so an interrupted
+// copy can simply be run again. The completion markers in the root of src are
+// skipped, and special files are skipped with a warning.
+func copyTree(ctx context.Context, src, dst string) error {
+	// Directory metadata is applied last, since copying into a directory
+	// changes its timestamps and a read-only directory could not be filled
+	type dirEntry struct {
+		path string
+		info fs.FileInfo
+	}
+	var dirs []dirEntry
+
+	err := filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel == snapshotCompleteMarker || rel == copyCompleteMarker {
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if err := os.Mkdir(target, 0700); err != nil && !os.IsExist(err) {
+				return err
+			}
+			dirs = append(dirs, dirEntry{path: target, info: info})
+			return nil
+
+		case mode&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+
+		case mode.IsRegular():
+			if err := copyFile(path, target); err != nil {
+				return err
+			}
+
+		default:
+			logrus.Warnf("Skipping special file %s with mode %s", path, mode)
+			return nil
+		}
+
+		return copyMetadata(target, info)
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := copyMetadata(dirs[i].path, dirs[i].info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies the contents of the regular file src to dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy %s: %v", src, err)
+	}
+	return out.Close()
+}
+
+// copyMetadata applies the ownership, permissions and timestamps of info to path
+func copyMetadata(path string, info fs.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("failed to read ownership of %s", info.Name())
+	}
+
+	if err := os.Lchown(path, int(st.Uid), int(st.Gid)); err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink == 0 {
+		mode := info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+
+	times := []unix.Timespec{
+		unix.NsecToTimespec(st.Atim.Nano()),
+		unix.NsecToTimespec(st.Mtim.Nano()),
+	}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, path, times, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "utimes", Path: path, Err: err}
+	}
+	return nil
+}
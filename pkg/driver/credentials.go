@@ -0,0 +1,234 @@
+package driver
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Keys of the CSI secrets holding Triton credentials. They match the keys of
+// the triton-creds secret, so the same layout serves every account.
+const (
+	secretCloudAPI   = "cloudapi"
+	secretAccountID  = "account-id"
+	secretKeyID      = "key-id"
+	secretPrivateKey = "key.pem"
+)
+
+// tritonCredentials are the credentials of a Triton account
+type tritonCredentials struct {
+	cloudAPI   string
+	accountID  string
+	keyID      string
+	privateKey []byte
+}
+
+// credentialsFromSecrets returns the Triton credentials in the secrets of a
+// CSI request. The CloudAPI endpoint defaults to the one of the driver.
+func (d *TritonNFSDriver) credentialsFromSecrets(secrets map[string]string) (*tritonCredentials, error) {
+	creds := &tritonCredentials{
+		cloudAPI:   secrets[secretCloudAPI],
+		accountID:  secrets[secretAccountID],
+		keyID:      secrets[secretKeyID],
+		privateKey: []byte(secrets[secretPrivateKey]),
+	}
+	if creds.cloudAPI == "" {
+		creds.cloudAPI = d.cloudAPI
+	}
+
+	switch {
+	case creds.accountID == "":
+		return nil, status.Errorf(codes.InvalidArgument, "Secrets must contain %s", secretAccountID)
+	case creds.keyID == "":
+		return nil, status.Errorf(codes.InvalidArgument, "Secrets must contain %s", secretKeyID)
+	case len(creds.privateKey) == 0:
+		return nil, status.Errorf(codes.InvalidArgument, "Secrets must contain %s", secretPrivateKey)
+	case creds.cloudAPI == "":
+		return nil, status.Errorf(codes.InvalidArgument, "Secrets must contain %s", secretCloudAPI)
+	}
+	return creds, nil
+}
+
+// pooledBackend is a backend of a backendPool, with a digest of the
+// credentials it was created with
+type pooledBackend struct {
+	cloudAPI  string
+	accountID string
+	digest    [sha256.Size]byte
+	backend   VolumeBackend
+}
+
+// backendCreation is a backend being created by a backendPool. done is
+// closed once backend and err are set. canceled is set when the request
+// creating the backend was done before it was created.
+type backendCreation struct {
+	digest   [sha256.Size]byte
+	done     chan struct{}
+	backend  VolumeBackend
+	err      error
+	canceled bool
+}
+
+// backendPool holds a backend per Triton account and CloudAPI endpoint. A
+// backend is created again when the key of its account changes. Backends are
+// created without holding the pool's lock, so that an unreachable endpoint or
+// a bad key only delays the requests of its own account.
+type backendPool struct {
+	mu         sync.Mutex
+	backends   map[string]*pooledBackend
+	creating   map[string]*backendCreation
+	newBackend func(ctx context.Context, creds *tritonCredentials) (VolumeBackend, error)
+}
+
+// newBackendPool creates an empty pool that creates TritonClients
+func newBackendPool() *backendPool {
+	return &backendPool{
+		backends: make(map[string]*pooledBackend),
+		creating: make(map[string]*backendCreation),
+		newBackend: func(ctx context.Context, creds *tritonCredentials) (VolumeBackend, error) {
+			return NewTritonClientWithKey(ctx, creds.cloudAPI, creds.accountID, creds.keyID, creds.privateKey)
+		},
+	}
+}
+
+// Get returns the backend for creds, creating it if needed. Requests for an
+// account whose backend is being created wait for it, until ctx is done.
+func (p *backendPool) Get(ctx context.Context, creds *tritonCredentials) (VolumeBackend, error) {
+	key := creds.cloudAPI + "/" + creds.accountID
+	digest := sha256.Sum256(append([]byte(creds.keyID+"\n"), creds.privateKey...))
+
+	for {
+		p.mu.Lock()
+		if pooled, ok := p.backends[key]; ok && pooled.digest == digest {
+			p.mu.Unlock()
+			return pooled.backend, nil
+		}
+		creation, ok := p.creating[key]
+		if !ok {
+			break
+		}
+		p.mu.Unlock()
+
+		select {
+		case <-creation.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// Share the outcome of a creation with the same key, unless it was
+		// cut short by the deadline of the request that started it
+		if creation.digest == digest && !creation.canceled {
+			return creation.backend, creation.err
+		}
+	}
+
+	creation := &backendCreation{digest: digest, done: make(chan struct{})}
+	p.creating[key] = creation
+	p.mu.Unlock()
+
+	logrus.Infof("Creating Triton client for account %s at %s with key %s", creds.accountID, creds.cloudAPI, creds.keyID)
+	creation.backend, creation.err = p.newBackend(ctx, creds)
+	creation.canceled = creation.err != nil && ctx.Err() != nil
+
+	p.mu.Lock()
+	delete(p.creating, key)
+	if creation.err == nil {
+		p.backends[key] = &pooledBackend{cloudAPI: creds.cloudAPI, accountID: creds.accountID, digest: digest, backend: creation.backend}
+	}
+	p.mu.Unlock()
+	close(creation.done)
+
+	return creation.backend, creation.err
+}
+
+// Accounts returns the backends of the pool for a CloudAPI endpoint by account
+func (p *backendPool) Accounts(cloudAPI string) map[string]VolumeBackend {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	backends := make(map[string]VolumeBackend)
+	for _, pooled := range p.backends {
+		if pooled.cloudAPI == cloudAPI {
+			backends[pooled.accountID] = pooled.backend
+		}
+	}
+	return backends
+}
+
+// requestTarget is the datacenter, Triton account and backend serving a request
+type requestTarget struct {
+	datacenter string
+	account    string
+	backend    VolumeBackend
+}
+
+// requestTargetKey is the context key of the requestTarget of a request
+type requestTargetKey struct{}
+
+// withBackend returns a context whose requests are served by backend in
+// datacenter, for a Triton account
+func withBackend(ctx context.Context, datacenter, account string, backend VolumeBackend) context.Context {
+	return context.WithValue(ctx, requestTargetKey{}, &requestTarget{datacenter: datacenter, account: account, backend: backend})
+}
+
+// volumeBackend returns the backend serving the request of ctx: the backend
+// of the request's datacenter and secrets, or the default backend
+func (d *TritonNFSDriver) volumeBackend(ctx context.Context) VolumeBackend {
+	if target, ok := ctx.Value(requestTargetKey{}).(*requestTarget); ok {
+		return target.backend
+	}
+	return d.backend
+}
+
+// requestDatacenter returns the datacenter serving the request of ctx
+func (d *TritonNFSDriver) requestDatacenter(ctx context.Context) string {
+	if target, ok := ctx.Value(requestTargetKey{}).(*requestTarget); ok {
+		return target.datacenter
+	}
+	return d.zone
+}
+
+// requestAccount returns the Triton account serving the request of ctx
+func (d *TritonNFSDriver) requestAccount(ctx context.Context) string {
+	if target, ok := ctx.Value(requestTargetKey{}).(*requestTarget); ok {
+		return target.account
+	}
+	return d.accountID
+}
+
+// checkVolumeAccount returns an error when a volume that the account serving
+// ctx cannot find is in another Triton account of the same datacenter: the
+// controller's account, or an account the controller has a client for. A
+// request with missing or wrong secrets then fails instead of taking the
+// volume of another account for deleted. Accounts without a client are not
+// searched.
+func (d *TritonNFSDriver) checkVolumeAccount(ctx context.Context, id, csiID string) error {
+	datacenter := d.requestDatacenter(ctx)
+	account := d.requestAccount(ctx)
+	cloudAPI, err := d.datacenterCloudAPI(datacenter)
+	if err != nil {
+		return err
+	}
+
+	backends := d.backends.Accounts(cloudAPI)
+	controllerCtx, err := d.withDatacenter(ctx, nil, datacenter)
+	if err != nil {
+		logrus.Warnf("Failed to look for volume %s in account %s: %v", csiID, d.accountID, err)
+	} else {
+		backends[d.accountID] = d.volumeBackend(controllerCtx)
+	}
+	delete(backends, account)
+
+	for other, backend := range backends {
+		_, err := backend.GetVolume(ctx, id)
+		switch {
+		case err == nil:
+			return status.Errorf(codes.FailedPrecondition, "Volume %s is in Triton account %s, not in account %s of the request", csiID, other, account)
+		case !IsNotFound(err):
+			logrus.Warnf("Failed to look for volume %s in account %s: %v", csiID, other, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+)
+
+func testCredentials(accountID string) *tritonCredentials {
+	return &tritonCredentials{
+		cloudAPI:   "https://cloudapi.example.com",
+		accountID:  accountID,
+		keyID:      "SHA256:key",
+		privateKey: []byte("key"),
+	}
+}
+
+func TestBackendPool(t *testing.T) {
+	unblock := make(chan struct{})
+	var created int32
+	pool := newBackendPool()
+	pool.newBackend = func(ctx context.Context, creds *tritonCredentials) (VolumeBackend, error) {
+		atomic.AddInt32(&created, 1)
+		if creds.accountID == "stuck" {
+			select {
+			case <-unblock:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if creds.accountID == "bad" {
+			return nil, errors.New("bad key")
+		}
+		return NewFakeBackend(), nil
+	}
+
+	// An account whose client cannot be created does not block the others
+	stuck := make(chan error, 1)
+	go func() {
+		_, err := pool.Get(context.Background(), testCredentials("stuck"))
+		stuck <- err
+	}()
+	for atomic.LoadInt32(&created) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	backend, err := pool.Get(ctx, testCredentials("team-a"))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	again, err := pool.Get(ctx, testCredentials("team-a"))
+	if err != nil || again != backend {
+		t.Errorf("expected the pooled backend, got %v (%v)", again, err)
+	}
+
+	// Requests waiting for the stuck account give up with their context
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer waitCancel()
+	if _, err := pool.Get(waitCtx, testCredentials("stuck")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the wait to time out, got %v", err)
+	}
+	close(unblock)
+	if err := <-stuck; err != nil {
+		t.Errorf("Get of the stuck account: %v", err)
+	}
+	if atomic.LoadInt32(&created) != 2 {
+		t.Errorf("expected 2 backends to be created, got %d", created)
+	}
+
+	if _, err := pool.Get(ctx, testCredentials("bad")); err == nil {
+		t.Error("expected an error for a bad key")
+	}
+	if _, ok := pool.Accounts("https://cloudapi.example.com")["bad"]; ok {
+		t.Error("expected the failed backend not to be pooled")
+	}
+
+	// A changed key creates a new backend
+	creds := testCredentials("team-a")
+	creds.privateKey = []byte("new key")
+	rotated, err := pool.Get(ctx, creds)
+	if err != nil || rotated == backend {
+		t.Errorf("expected a new backend for the new key, got %v (%v)", rotated, err)
+	}
+}
+
+func TestDeleteVolumeOtherAccount(t *testing.T) {
+	d, err := NewTritonNFSDriver(WithMode(ModeController), WithCloudAPI("https://cloudapi.example.com"), WithVolumeBackend(NewFakeBackend()))
+	if err != nil {
+		t.Fatalf("NewTritonNFSDriver: %v", err)
+	}
+	ctx := context.Background()
+
+	// A volume of another account the controller has a client for
+	other := NewFakeBackend()
+	d.backends.newBackend = func(ctx context.Context, creds *tritonCredentials) (VolumeBackend, error) {
+		return other, nil
+	}
+	secrets := map[string]string{
+		secretAccountID:  "team-b",
+		secretKeyID:      "SHA256:key",
+		secretPrivateKey: "key",
+	}
+	createReq := createVolumeRequest("pvc-1", 0)
+	createReq.Secrets = secrets
+	resp, err := d.CreateVolume(ctx, createReq)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	volumeID := resp.GetVolume().GetVolumeId()
+
+	// Deleting it without the secrets does not report it deleted
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+	expectCode(t, err, codes.FailedPrecondition)
+	if _, err := other.GetVolume(ctx, volumeID); err != nil {
+		t.Fatalf("expected volume %s to be kept: %v", volumeID, err)
+	}
+
+	if _, err := d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID, Secrets: secrets}); err != nil {
+		t.Fatalf("DeleteVolume with the secrets: %v", err)
+	}
+	if _, err := d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+		t.Errorf("DeleteVolume of a deleted volume: %v", err)
+	}
+}
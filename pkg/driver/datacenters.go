@@ -0,0 +1,254 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// paramDatacenter selects the datacenter CreateVolume creates a volume in
+const paramDatacenter = "datacenter"
+
+// parseDatacenters parses a comma-separated list of name=CloudAPI URL pairs
+func parseDatacenters(spec string) (map[string]string, error) {
+	datacenters := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, cloudAPI, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || cloudAPI == "" {
+			return nil, fmt.Errorf("invalid datacenter %q, must be name=CloudAPI URL", entry)
+		}
+		if strings.ContainsAny(name, ":/?") {
+			return nil, fmt.Errorf("invalid datacenter name %q", name)
+		}
+		if _, err := url.ParseRequestURI(cloudAPI); err != nil {
+			return nil, fmt.Errorf("invalid CloudAPI URL of datacenter %s: %v", name, err)
+		}
+		if _, ok := datacenters[name]; ok {
+			return nil, fmt.Errorf("datacenter %s is configured twice", name)
+		}
+		datacenters[name] = cloudAPI
+	}
+	return datacenters, nil
+}
+
+// datacenterNames returns the controller's datacenter followed by the other
+// configured datacenters
+func (d *TritonNFSDriver) datacenterNames() []string {
+	var others []string
+	for name := range d.datacenters {
+		if name != d.zone {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	return append([]string{d.zone}, others...)
+}
+
+// datacenterCloudAPI returns the CloudAPI endpoint of a datacenter
+func (d *TritonNFSDriver) datacenterCloudAPI(datacenter string) (string, error) {
+	if datacenter == d.zone {
+		return d.cloudAPI, nil
+	}
+	if cloudAPI, ok := d.datacenters[datacenter]; ok {
+		return cloudAPI, nil
+	}
+	return "", status.Errorf(codes.FailedPrecondition, "Datacenter %s is not configured", datacenter)
+}
+
+// withDatacenter returns a context whose requests are served by a datacenter,
+// the controller's own when datacenter is empty. Requests use the Triton
+// account of secrets, or the controller's account without secrets.
+func (d *TritonNFSDriver) withDatacenter(ctx context.Context, secrets map[string]string, datacenter string) (context.Context, error) {
+	if datacenter == "" {
+		datacenter = d.zone
+	}
+	cloudAPI, err := d.datacenterCloudAPI(datacenter)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds *tritonCredentials
+	switch {
+	case len(secrets) > 0:
+		creds, err = d.credentialsFromSecrets(secrets)
+		if err != nil {
+			return nil, err
+		}
+		// The CloudAPI endpoint of a secret is the one of the controller's datacenter
+		if datacenter != d.zone {
+			creds.cloudAPI = cloudAPI
+		}
+	case datacenter == d.zone:
+		return withBackend(ctx, datacenter, d.accountID, d.backend), nil
+	default:
+		privateKey, err := os.ReadFile(d.keyPath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to read private key: %v", err)
+		}
+		creds = &tritonCredentials{
+			cloudAPI:   cloudAPI,
+			accountID:  d.accountID,
+			keyID:      d.keyID,
+			privateKey: privateKey,
+		}
+	}
+
+	backend, err := d.backends.Get(ctx, creds)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "Failed to create Triton client for account %s in datacenter %s: %v", creds.accountID, datacenter, err)
+	}
+	return withBackend(ctx, datacenter, creds.accountID, backend), nil
+}
+
+// splitVolumeID splits a CSI volume or snapshot ID into the datacenter it
+// encodes and the ID within that datacenter. IDs without a datacenter, such
+// as those created before datacenters were configured, are in the
+// controller's datacenter and the returned datacenter is empty.
+func (d *TritonNFSDriver) splitVolumeID(id string) (string, string) {
+	if len(d.datacenters) == 0 {
+		return "", id
+	}
+	datacenter, localID, ok := strings.Cut(id, ":")
+	if !ok || strings.ContainsAny(datacenter, "/?") {
+		return "", id
+	}
+	return datacenter, localID
+}
+
+// withVolumeDatacenter returns a context served by the datacenter of a CSI
+// volume or snapshot ID, like withDatacenter, and the ID within the datacenter
+func (d *TritonNFSDriver) withVolumeDatacenter(ctx context.Context, secrets map[string]string, id string) (context.Context, string, error) {
+	datacenter, localID := d.splitVolumeID(id)
+	ctx, err := d.withDatacenter(ctx, secrets, datacenter)
+	if err != nil {
+		return nil, "", err
+	}
+	return ctx, localID, nil
+}
+
+// csiVolumeID returns the CSI ID of a volume or snapshot in the datacenter
+// serving ctx. IDs only encode the datacenter when datacenters are configured.
+func (d *TritonNFSDriver) csiVolumeID(ctx context.Context, localID string) string {
+	if len(d.datacenters) == 0 || localID == "" {
+		return localID
+	}
+	return d.requestDatacenter(ctx) + ":" + localID
+}
+
+// localVolumeID returns the ID within the datacenter serving ctx of a CSI
+// volume or snapshot ID. Volumes of other datacenters are rejected.
+func (d *TritonNFSDriver) localVolumeID(ctx context.Context, id string) (string, error) {
+	datacenter, localID := d.splitVolumeID(id)
+	if datacenter == "" {
+		datacenter = d.zone
+	}
+	if datacenter != d.requestDatacenter(ctx) {
+		return "", status.Errorf(codes.InvalidArgument, "Volume %s is in datacenter %s, not in %s", id, datacenter, d.requestDatacenter(ctx))
+	}
+	return localID, nil
+}
+
+// withCSIVolumeID returns a copy of a volume of the datacenter serving ctx
+// that carries its CSI ID
+func (d *TritonNFSDriver) withCSIVolumeID(ctx context.Context, volume *NFSVolume) *NFSVolume {
+	listed := *volume
+	listed.ID = d.csiVolumeID(ctx, volume.ID)
+	return &listed
+}
+
+// localContentSource returns a content source with the IDs of its snapshot or
+// volume within the datacenter serving ctx
+func (d *TritonNFSDriver) localContentSource(ctx context.Context, source *csi.VolumeContentSource) (*csi.VolumeContentSource, error) {
+	switch {
+	case source.GetSnapshot() != nil:
+		id, err := d.localVolumeID(ctx, source.GetSnapshot().GetSnapshotId())
+		if err != nil {
+			return nil, err
+		}
+		return &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: id},
+			},
+		}, nil
+	case source.GetVolume() != nil:
+		id, err := d.localVolumeID(ctx, source.GetVolume().GetVolumeId())
+		if err != nil {
+			return nil, err
+		}
+		return &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: id},
+			},
+		}, nil
+	}
+	return source, nil
+}
+
+// csiContentSource returns a content source with the CSI IDs of its snapshot
+// or volume in the datacenter serving ctx
+func (d *TritonNFSDriver) csiContentSource(ctx context.Context, source *csi.VolumeContentSource) *csi.VolumeContentSource {
+	switch {
+	case source.GetSnapshot() != nil:
+		return &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{
+					SnapshotId: d.csiVolumeID(ctx, source.GetSnapshot().GetSnapshotId()),
+				},
+			},
+		}
+	case source.GetVolume() != nil:
+		return &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{
+					VolumeId: d.csiVolumeID(ctx, source.GetVolume().GetVolumeId()),
+				},
+			},
+		}
+	}
+	return source
+}
+
+// volumeDatacenter returns the datacenter to create a volume in: the one of
+// the datacenter parameter, or else the zone of the first preferred, then
+// requisite, topology that is a configured datacenter, or else the datacenter
+// of the content source or parent volume. It returns an empty string for the
+// controller's own datacenter.
+func (d *TritonNFSDriver) volumeDatacenter(params map[string]string, requirements *csi.TopologyRequirement, source *csi.VolumeContentSource) (string, error) {
+	if datacenter := params[paramDatacenter]; datacenter != "" {
+		if _, err := d.datacenterCloudAPI(datacenter); err != nil {
+			return "", status.Errorf(codes.InvalidArgument, "Datacenter %s is not configured", datacenter)
+		}
+		return datacenter, nil
+	}
+
+	for _, topology := range append(append([]*csi.Topology{}, requirements.GetPreferred()...), requirements.GetRequisite()...) {
+		zone, ok := topology.GetSegments()[TopologyKeyZone]
+		if !ok {
+			continue
+		}
+		if zone == d.zone {
+			return "", nil
+		}
+		if _, ok := d.datacenters[zone]; ok {
+			return zone, nil
+		}
+	}
+
+	for _, id := range []string{source.GetSnapshot().GetSnapshotId(), source.GetVolume().GetVolumeId(), params[paramParentVolumeID]} {
+		if datacenter, _ := d.splitVolumeID(id); datacenter != "" {
+			return datacenter, nil
+		}
+	}
+	return "", nil
+}
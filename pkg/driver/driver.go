@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
@@ -21,22 +23,66 @@ var (
 	DriverVersion = "v0.5.6" // Default value, will be overridden during build
 )
 
+// Driver modes select which CSI services the driver serves
+const (
+	// ModeController serves the Identity and Controller services
+	ModeController = "controller"
+
+	// ModeNode serves the Identity and Node services and needs no Triton credentials
+	ModeNode = "node"
+
+	// ModeAll serves the Identity, Controller and Node services
+	ModeAll = "all"
+)
+
 // TritonNFSDriver implements the CSI driver interface for Triton NFS volumes
 type TritonNFSDriver struct {
-	endpoint   string
-	nodeID     string
-	cloudAPI   string
-	accountID  string
-	keyID      string
-	keyPath    string
-	server     *grpc.Server
-	mounter    mount.Interface
-	tritonClient *TritonClient
+	mode           string
+	endpoint       string
+	metricsAddress string
+	nodeID         string
+	clusterID      string
+	zone           string
+	nodeNetwork    string
+	datacenters    map[string]string
+	cloudAPI       string
+	accountID      string
+	keyID          string
+	keyPath        string
+	server         *grpc.Server
+	metricsServer  *http.Server
+	mounter        mount.Interface
+	backend        VolumeBackend
+
+	operationLocks *operationLocks
+	volumeCopies   *volumeCopies
+
+	// backends holds the backends of the accounts in request secrets. The
+	// default backend serves requests without secrets.
+	backends *backendPool
+
+	// provisionStarts holds the provisionStart of each volume name that is
+	// not ready yet, for the time-to-ready metric. Entries are dropped once
+	// the volume is ready, failed or deleted.
+	provisionStarts sync.Map
 }
 
 // DriverOption is a functional option for configuring the driver
 type DriverOption func(*TritonNFSDriver) error
 
+// WithMode sets which CSI services the driver serves
+func WithMode(mode string) DriverOption {
+	return func(driver *TritonNFSDriver) error {
+		switch mode {
+		case ModeController, ModeNode, ModeAll:
+			driver.mode = mode
+			return nil
+		default:
+			return fmt.Errorf("invalid mode %q, must be one of %s, %s or %s", mode, ModeController, ModeNode, ModeAll)
+		}
+	}
+}
+
 // WithEndpoint sets the endpoint for the driver
 func WithEndpoint(endpoint string) DriverOption {
 	return func(driver *TritonNFSDriver) error {
@@ -45,6 +91,15 @@ func WithEndpoint(endpoint string) DriverOption {
 	}
 }
 
+// WithMetricsAddress sets the address of the HTTP listener serving Prometheus
+// metrics. Metrics are not served when it is empty.
+func WithMetricsAddress(addr string) DriverOption {
+	return func(driver *TritonNFSDriver) error {
+		driver.metricsAddress = addr
+		return nil
+	}
+}
+
 // WithNodeID sets the node ID for the driver
 func WithNodeID(nodeID string) DriverOption {
 	return func(driver *TritonNFSDriver) error {
@@ -53,6 +108,51 @@ func WithNodeID(nodeID string) DriverOption {
 	}
 }
 
+// WithClusterID sets the cluster ID the driver tags its volumes with. The
+// controller only lists and adopts by name the volumes tagged with it.
+func WithClusterID(clusterID string) DriverOption {
+	return func(driver *TritonNFSDriver) error {
+		driver.clusterID = clusterID
+		return nil
+	}
+}
+
+// WithZone sets the Triton datacenter the driver runs in. Nodes report it as
+// their zone topology segment, and the controller only provisions volumes for
+// topologies in it.
+func WithZone(zone string) DriverOption {
+	return func(driver *TritonNFSDriver) error {
+		driver.zone = zone
+		return nil
+	}
+}
+
+// WithNodeNetwork sets the ID of the Triton network the node mounts NFS
+// volumes through, reported as its network topology segment
+func WithNodeNetwork(networkID string) DriverOption {
+	return func(driver *TritonNFSDriver) error {
+		if networkID != "" && !uuidPattern.MatchString(networkID) {
+			return fmt.Errorf("invalid node network %q, must be a network ID", networkID)
+		}
+		driver.nodeNetwork = networkID
+		return nil
+	}
+}
+
+// WithDatacenters sets the Triton datacenters the controller provisions volumes
+// in besides its own, as a comma-separated list of name=CloudAPI URL pairs.
+// Volume IDs encode their datacenter when datacenters are set.
+func WithDatacenters(datacenters string) DriverOption {
+	return func(driver *TritonNFSDriver) error {
+		parsed, err := parseDatacenters(datacenters)
+		if err != nil {
+			return err
+		}
+		driver.datacenters = parsed
+		return nil
+	}
+}
+
 // WithCloudAPI sets the CloudAPI endpoint for the driver
 func WithCloudAPI(cloudAPI string) DriverOption {
 	return func(driver *TritonNFSDriver) error {
@@ -85,10 +185,23 @@ func WithKeyPath(keyPath string) DriverOption {
 	}
 }
 
+// WithVolumeBackend sets the volume backend used by the controller service.
+// When not set, a TritonClient is created from the CloudAPI options.
+func WithVolumeBackend(backend VolumeBackend) DriverOption {
+	return func(driver *TritonNFSDriver) error {
+		driver.backend = backend
+		return nil
+	}
+}
+
 // NewTritonNFSDriver creates a new TritonNFSDriver with the given options
 func NewTritonNFSDriver(opts ...DriverOption) (*TritonNFSDriver, error) {
 	driver := &TritonNFSDriver{
-		mounter: mount.New(""),
+		mode:           ModeAll,
+		mounter:        mount.New(""),
+		operationLocks: newOperationLocks(),
+		volumeCopies:   newVolumeCopies(),
+		backends:       newBackendPool(),
 	}
 
 	for _, opt := range opts {
@@ -97,11 +210,24 @@ func NewTritonNFSDriver(opts ...DriverOption) (*TritonNFSDriver, error) {
 		}
 	}
 
-	tritonClient, err := NewTritonClient(driver.cloudAPI, driver.accountID, driver.keyID, driver.keyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Triton client: %v", err)
+	// Volumes of the controller's own datacenter are encoded with its zone
+	if len(driver.datacenters) > 0 && driver.servesController() {
+		if driver.zone == "" {
+			return nil, fmt.Errorf("the zone must name the datacenter of the CloudAPI endpoint when datacenters are configured")
+		}
+		if cloudAPI, ok := driver.datacenters[driver.zone]; ok && cloudAPI != driver.cloudAPI {
+			return nil, fmt.Errorf("datacenter %s is configured with CloudAPI endpoint %s, but the controller uses %s", driver.zone, cloudAPI, driver.cloudAPI)
+		}
+	}
+
+	// Only the controller service talks to Triton
+	if driver.backend == nil && driver.servesController() {
+		tritonClient, err := NewTritonClient(driver.cloudAPI, driver.accountID, driver.keyID, driver.keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Triton client: %v", err)
+		}
+		driver.backend = tritonClient
 	}
-	driver.tritonClient = tritonClient
 
 	return driver, nil
 }
@@ -113,7 +239,7 @@ func (d *TritonNFSDriver) Run() error {
 		return err
 	}
 
-	logrus.Infof("Starting Triton NFS CSI driver version %s at %s", DriverVersion, d.endpoint)
+	logrus.Infof("Starting Triton NFS CSI driver version %s in %s mode at %s", DriverVersion, d.mode, d.endpoint)
 	listener, err := net.Listen(scheme, addr)
 	if err != nil {
 		return err
@@ -121,14 +247,23 @@ func (d *TritonNFSDriver) Run() error {
 
 	logrus.Infof("Listening for connections on address: %#v", listener.Addr())
 
+	if d.metricsAddress != "" {
+		d.metricsServer = newMetricsServer(d.metricsAddress)
+		go serveMetrics(d.metricsServer)
+	}
+
 	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logGRPC),
+		grpc.ChainUnaryInterceptor(observeGRPC, logGRPC),
 	}
 	d.server = grpc.NewServer(opts...)
 
 	csi.RegisterIdentityServer(d.server, d)
-	csi.RegisterControllerServer(d.server, d)
-	csi.RegisterNodeServer(d.server, d)
+	if d.servesController() {
+		csi.RegisterControllerServer(d.server, d)
+	}
+	if d.servesNode() {
+		csi.RegisterNodeServer(d.server, d)
+	}
 
 	return d.server.Serve(listener)
 }
@@ -138,6 +273,19 @@ func (d *TritonNFSDriver) Stop() {
 	if d.server != nil {
 		d.server.Stop()
 	}
+	if d.metricsServer != nil {
+		d.metricsServer.Close()
+	}
+}
+
+// servesController reports whether the driver serves the Controller service
+func (d *TritonNFSDriver) servesController() bool {
+	return d.mode == ModeController || d.mode == ModeAll
+}
+
+// servesNode reports whether the driver serves the Node service
+func (d *TritonNFSDriver) servesNode() bool {
+	return d.mode == ModeNode || d.mode == ModeAll
 }
 
 func parseEndpoint(endpoint string) (string, string, error) {
@@ -173,4 +321,4 @@ func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, h
 		logrus.Debugf("GRPC response: %s", protosanitizer.StripSecrets(resp))
 	}
 	return resp, err
-}
\ No newline at end of file
+}
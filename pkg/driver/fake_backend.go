@@ -0,0 +1,423 @@
+package driver
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	tritonerrors "github.com/joyent/triton-go/v2/errors"
+)
+
+// Operation names accepted by FakeBackend.InjectError
+const (
+	FakeOpCreateVolume = "CreateVolume"
+	FakeOpGetVolume    = "GetVolume"
+	FakeOpDeleteVolume = "DeleteVolume"
+	FakeOpExpandVolume = "ExpandVolume"
+	FakeOpListVolumes  = "ListVolumes"
+	FakeOpFindVolumes  = "FindVolumes"
+	FakeOpListNetworks = "ListNetworks"
+
+	FakeOpSetVolumeTags   = "SetVolumeTags"
+	FakeOpListVolumeSizes = "ListVolumeSizes"
+)
+
+// FakeBackendServer is the NFS server address reported for FakeBackend volumes
+const FakeBackendServer = "127.0.0.1"
+
+// FakeBackendNetwork is the network FakeBackend offers by default, on which
+// FakeBackendServer serves volumes
+var FakeBackendNetwork = Network{
+	ID:     "a3e1c7c4-4a7b-4d1e-9d6c-0c2f5b6e8f01",
+	Name:   "fake-network",
+	Subnet: "127.0.0.0/8",
+}
+
+// FakeBackend is an in-memory VolumeBackend. New volumes start in the
+// creating state and move to ready (or failed) once the configured ready
+// delay has passed, and expanded volumes stay resizing for the same delay.
+// Errors and latency can be injected per operation.
+type FakeBackend struct {
+	mu         sync.Mutex
+	volumes    map[string]*fakeVolume
+	errors     map[string]error
+	failNames  map[string]bool
+	sizes      []int64
+	networks   []*Network
+	latency    time.Duration
+	readyAfter time.Duration
+}
+
+type fakeVolume struct {
+	volume  NFSVolume
+	readyAt time.Time
+	fail    bool
+}
+
+// NewFakeBackend creates an empty FakeBackend whose volumes become ready
+// immediately. It offers the sizes of a stock Triton installation: 10 to 100 GiB
+// in 10 GiB steps, then up to 1000 GiB in 100 GiB steps.
+func NewFakeBackend() *FakeBackend {
+	const gib = 1024 * 1024 * 1024
+	var sizes []int64
+	for size := int64(10); size <= 1000; {
+		sizes = append(sizes, size*gib)
+		if size < 100 {
+			size += 10
+		} else {
+			size += 100
+		}
+	}
+
+	return &FakeBackend{
+		volumes:   make(map[string]*fakeVolume),
+		errors:    make(map[string]error),
+		failNames: make(map[string]bool),
+		sizes:     sizes,
+		networks:  []*Network{&FakeBackendNetwork},
+	}
+}
+
+// SetNetworks replaces the networks offered by the backend
+func (b *FakeBackend) SetNetworks(networks []*Network) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.networks = networks
+}
+
+// SetVolumeSizes replaces the offered volume sizes, in bytes
+func (b *FakeBackend) SetVolumeSizes(sizes []int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sizes = append([]int64(nil), sizes...)
+	sort.Slice(b.sizes, func(i, j int) bool { return b.sizes[i] < b.sizes[j] })
+}
+
+// SetLatency sets a delay applied to every operation
+func (b *FakeBackend) SetLatency(latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.latency = latency
+}
+
+// SetReadyAfter sets how long new volumes stay in the creating state, and
+// expanded volumes in the resizing state
+func (b *FakeBackend) SetReadyAfter(readyAfter time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.readyAfter = readyAfter
+}
+
+// InjectError makes every call to the given operation fail with err.
+// Passing a nil error clears the injected error.
+func (b *FakeBackend) InjectError(op string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		delete(b.errors, op)
+		return
+	}
+	b.errors[op] = err
+}
+
+// FailVolume makes volumes created with the given name end up in the failed
+// state instead of ready
+func (b *FakeBackend) FailVolume(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failNames[name] = true
+}
+
+// SetVolumeState forces the state of an existing volume
+func (b *FakeBackend) SetVolumeState(id, state string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, ok := b.volumes[id]
+	if !ok {
+		return fakeNotFound(id)
+	}
+	v.volume.State = state
+	return nil
+}
+
+// CreateVolume creates a new in-memory volume in the creating state
+func (b *FakeBackend) CreateVolume(ctx context.Context, req *NFSVolumeRequest) (*NFSVolume, error) {
+	if err := b.begin(ctx, FakeOpCreateVolume); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, v := range b.volumes {
+		if v.volume.Name == req.Name {
+			return nil, &tritonerrors.APIError{
+				StatusCode: http.StatusConflict,
+				Code:       "VolumeAlreadyExists",
+				Message:    fmt.Sprintf("volume with name %s already exists", req.Name),
+			}
+		}
+	}
+
+	id, err := newFakeVolumeID()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string, len(req.Tags))
+	for k, v := range req.Tags {
+		tags[k] = v
+	}
+
+	var networks []Network
+	for _, netID := range req.Networks {
+		networks = append(networks, Network{ID: netID})
+	}
+
+	path := fmt.Sprintf("%s:/exports/%s", FakeBackendServer, id)
+	v := &fakeVolume{
+		volume: NFSVolume{
+			ID:             id,
+			Name:           req.Name,
+			State:          VolumeStateCreating,
+			Type:           TritonVolumeTypeNFS,
+			Networks:       networks,
+			Size:           roundUpToMB(req.Size),
+			MountPoint:     path,
+			FileSystemPath: path,
+			Created:        time.Now(),
+			Tags:           tags,
+		},
+		readyAt: time.Now().Add(b.readyAfter),
+		fail:    b.failNames[req.Name],
+	}
+	describeNetworks(&v.volume, b.networks)
+	b.volumes[id] = v
+
+	return v.snapshot(), nil
+}
+
+// GetVolume gets a volume by ID
+func (b *FakeBackend) GetVolume(ctx context.Context, id string) (*NFSVolume, error) {
+	if err := b.begin(ctx, FakeOpGetVolume); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	v, ok := b.volumes[id]
+	if !ok {
+		return nil, fakeNotFound(id)
+	}
+	return v.snapshot(), nil
+}
+
+// DeleteVolume deletes a volume by ID
+func (b *FakeBackend) DeleteVolume(ctx context.Context, id string) error {
+	if err := b.begin(ctx, FakeOpDeleteVolume); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.volumes[id]; !ok {
+		return fakeNotFound(id)
+	}
+	delete(b.volumes, id)
+	return nil
+}
+
+// ExpandVolume starts growing a volume to at least newSize bytes
+func (b *FakeBackend) ExpandVolume(ctx context.Context, id string, newSize int64) (*NFSVolume, error) {
+	if err := b.begin(ctx, FakeOpExpandVolume); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	v, ok := b.volumes[id]
+	if !ok {
+		return nil, fakeNotFound(id)
+	}
+	volume := v.snapshot()
+	if volume.Size >= newSize {
+		return volume, nil
+	}
+	if volume.State != VolumeStateReady {
+		return nil, &tritonerrors.APIError{
+			StatusCode: http.StatusConflict,
+			Code:       "VolumeNotReady",
+			Message:    fmt.Sprintf("volume %s is %s, it must be ready to be resized", id, volume.State),
+		}
+	}
+
+	size, err := selectVolumeSize(b.sizes, newSize, 0)
+	if err != nil {
+		return nil, err
+	}
+	v.volume.Size = size
+	v.volume.State = VolumeStateResizing
+	v.readyAt = time.Now().Add(b.readyAfter)
+	return v.snapshot(), nil
+}
+
+// SetVolumeTags sets tags on a volume, keeping its other tags
+func (b *FakeBackend) SetVolumeTags(ctx context.Context, id string, tags map[string]string) (*NFSVolume, error) {
+	if err := b.begin(ctx, FakeOpSetVolumeTags); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	v, ok := b.volumes[id]
+	if !ok {
+		return nil, fakeNotFound(id)
+	}
+	if v.volume.Tags == nil {
+		v.volume.Tags = make(map[string]string, len(tags))
+	}
+	for k, val := range tags {
+		v.volume.Tags[k] = val
+	}
+	return v.snapshot(), nil
+}
+
+// ListVolumes lists all volumes ordered by creation time
+func (b *FakeBackend) ListVolumes(ctx context.Context) ([]*NFSVolume, error) {
+	if err := b.begin(ctx, FakeOpListVolumes); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	volumes := make([]*NFSVolume, 0, len(b.volumes))
+	for _, v := range b.volumes {
+		volumes = append(volumes, v.snapshot())
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Created.Before(volumes[j].Created)
+	})
+	return volumes, nil
+}
+
+// FindVolumes lists the volumes selected by filter ordered by creation time
+func (b *FakeBackend) FindVolumes(ctx context.Context, filter *VolumeFilter) ([]*NFSVolume, error) {
+	if err := b.begin(ctx, FakeOpFindVolumes); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var volumes []*NFSVolume
+	for _, v := range b.volumes {
+		if volume := v.snapshot(); filter.Matches(volume) {
+			volumes = append(volumes, volume)
+		}
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Created.Before(volumes[j].Created)
+	})
+	return volumes, nil
+}
+
+// ListNetworks lists the offered networks
+func (b *FakeBackend) ListNetworks(ctx context.Context) ([]*Network, error) {
+	if err := b.begin(ctx, FakeOpListNetworks); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	networks := make([]*Network, len(b.networks))
+	for i, network := range b.networks {
+		n := *network
+		networks[i] = &n
+	}
+	return networks, nil
+}
+
+// ListVolumeSizes returns the offered volume sizes in bytes
+func (b *FakeBackend) ListVolumeSizes(ctx context.Context) ([]int64, error) {
+	if err := b.begin(ctx, FakeOpListVolumeSizes); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]int64(nil), b.sizes...), nil
+}
+
+// begin applies the configured latency and returns any injected error for op
+func (b *FakeBackend) begin(ctx context.Context, op string) error {
+	b.mu.Lock()
+	latency := b.latency
+	err := b.errors[op]
+	b.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(latency):
+		}
+	}
+	return err
+}
+
+// snapshot advances the state of a creating or resizing volume and returns a
+// copy of it. Callers must hold the backend lock.
+func (v *fakeVolume) snapshot() *NFSVolume {
+	if v.volume.State == VolumeStateResizing && !time.Now().Before(v.readyAt) {
+		v.volume.State = VolumeStateReady
+	}
+	if v.volume.State == VolumeStateCreating && !time.Now().Before(v.readyAt) {
+		if v.fail {
+			v.volume.State = VolumeStateFailed
+		} else {
+			v.volume.State = VolumeStateReady
+		}
+	}
+
+	volume := v.volume
+	volume.Networks = append([]Network(nil), v.volume.Networks...)
+	volume.Tags = make(map[string]string, len(v.volume.Tags))
+	for k, val := range v.volume.Tags {
+		volume.Tags[k] = val
+	}
+	return &volume
+}
+
+func fakeNotFound(id string) error {
+	return &tritonerrors.APIError{
+		StatusCode: http.StatusNotFound,
+		Code:       "ResourceNotFound",
+		Message:    fmt.Sprintf("volume %s not found", id),
+	}
+}
+
+func newFakeVolumeID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate volume ID: %v", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// roundUpToMB rounds a size in bytes up to a whole number of megabytes
+func roundUpToMB(size int64) int64 {
+	const mb = 1024 * 1024
+	return (size + mb - 1) / mb * mb
+}
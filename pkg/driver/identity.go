@@ -10,7 +10,7 @@ import (
 
 // GetPluginInfo returns metadata about the CSI plugin
 func (d *TritonNFSDriver) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
-	if d.nodeID == "" {
+	if d.nodeID == "" && d.servesNode() {
 		return nil, status.Error(codes.Unavailable, "Node ID not configured")
 	}
 
@@ -24,23 +24,33 @@ func (d *TritonNFSDriver) GetPluginInfo(ctx context.Context, req *csi.GetPluginI
 
 // GetPluginCapabilities returns the capabilities of the CSI plugin
 func (d *TritonNFSDriver) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
-	resp := &csi.GetPluginCapabilitiesResponse{
-		Capabilities: []*csi.PluginCapability{
-			{
+	resp := &csi.GetPluginCapabilitiesResponse{}
+
+	// Controller capabilities are only advertised by plugins serving the controller service
+	if d.servesController() {
+		resp.Capabilities = append(resp.Capabilities,
+			&csi.PluginCapability{
 				Type: &csi.PluginCapability_Service_{
 					Service: &csi.PluginCapability_Service{
 						Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
 					},
 				},
 			},
-			{
+			&csi.PluginCapability{
+				Type: &csi.PluginCapability_Service_{
+					Service: &csi.PluginCapability_Service{
+						Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+					},
+				},
+			},
+			&csi.PluginCapability{
 				Type: &csi.PluginCapability_VolumeExpansion_{
 					VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
 						Type: csi.PluginCapability_VolumeExpansion_ONLINE,
 					},
 				},
 			},
-		},
+		)
 	}
 
 	return resp, nil
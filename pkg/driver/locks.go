@@ -0,0 +1,57 @@
+package driver
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Prefixes that keep operation lock keys of different kinds apart
+const (
+	lockPrefixVolumeName = "name/"
+	lockPrefixVolumeID   = "volume/"
+	lockPrefixTargetPath = "path/"
+)
+
+// operationLocks tracks the keys of operations that are in flight. The CSI
+// spec asks plugins to reject a request with Aborted while another operation
+// on the same volume is still running, rather than queueing it.
+type operationLocks struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// newOperationLocks creates an empty set of operation locks
+func newOperationLocks() *operationLocks {
+	return &operationLocks{
+		keys: make(map[string]struct{}),
+	}
+}
+
+// TryAcquire takes the lock for key and reports whether it was free
+func (l *operationLocks) TryAcquire(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.keys[key]; ok {
+		return false
+	}
+	l.keys[key] = struct{}{}
+	return true
+}
+
+// Release frees the lock for key
+func (l *operationLocks) Release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.keys, key)
+}
+
+// lockOperation takes the operation lock for key, returning Aborted if another
+// operation holds it. The returned function releases the lock.
+func (d *TritonNFSDriver) lockOperation(key string) (func(), error) {
+	if !d.operationLocks.TryAcquire(key) {
+		return nil, status.Errorf(codes.Aborted, "An operation on %s is already in progress", key)
+	}
+	return func() { d.operationLocks.Release(key) }, nil
+}
@@ -0,0 +1,166 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// metricsNamespace prefixes the names of all metrics exported by the driver
+const metricsNamespace = "tritonnfs_csi"
+
+var (
+	// metricsRegistry holds the driver metrics served on the metrics address
+	metricsRegistry = prometheus.NewRegistry()
+
+	csiOperationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "operations_total",
+		Help:      "Number of CSI RPCs handled, by method and gRPC code.",
+	}, []string{"method", "grpc_code"})
+
+	csiOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of CSI RPCs, by method and gRPC code.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"method", "grpc_code"})
+
+	csiOperationsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "operations_in_flight",
+		Help:      "Number of CSI RPCs currently being handled, by method.",
+	}, []string{"method"})
+
+	cloudAPIRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cloudapi_requests_total",
+		Help:      "Number of CloudAPI calls, by operation and HTTP status. Calls without a response are labelled with their error class.",
+	}, []string{"operation", "status"})
+
+	cloudAPIRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "cloudapi_request_duration_seconds",
+		Help:      "Duration of CloudAPI calls including retries, by operation and HTTP status.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"operation", "status"})
+
+	cloudAPIRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cloudapi_retries_total",
+		Help:      "Number of times CloudAPI calls were retried after a transient error, by operation.",
+	}, []string{"operation"})
+
+	volumeTimeToReady = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "volume_time_to_ready_seconds",
+		Help:      "Time from the first CreateVolume call for a volume until it was returned ready.",
+		Buckets:   []float64{5, 10, 20, 30, 60, 90, 120, 180, 300, 600, 900},
+	})
+
+	volumesByState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "volumes",
+		Help:      "Number of NFS volumes by state, as of the last ListVolumes call.",
+	}, []string{"state"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		csiOperationsTotal,
+		csiOperationDuration,
+		csiOperationsInFlight,
+		cloudAPIRequestsTotal,
+		cloudAPIRequestDuration,
+		cloudAPIRetriesTotal,
+		volumeTimeToReady,
+		volumesByState,
+	)
+}
+
+// MetricsHandler returns an HTTP handler serving the driver metrics
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// serveMetrics runs the metrics server until it is closed
+func serveMetrics(server *http.Server) {
+	logrus.Infof("Serving metrics on %s/metrics", server.Addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logrus.Errorf("Metrics server failed: %v", err)
+	}
+}
+
+// newMetricsServer creates the HTTP server for the metrics endpoint
+func newMetricsServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", MetricsHandler())
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// observeGRPC records the count, duration and concurrency of CSI RPCs
+func observeGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method := path.Base(info.FullMethod)
+
+	inFlight := csiOperationsInFlight.WithLabelValues(method)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	code := status.Code(err).String()
+	csiOperationsTotal.WithLabelValues(method, code).Inc()
+	csiOperationDuration.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+
+	return resp, err
+}
+
+// observeCloudAPICall records a finished CloudAPI call that was retried
+// retries times. err is nil or the *CloudAPIError returned by callCloudAPI.
+func observeCloudAPICall(op string, start time.Time, retries int, err error) {
+	statusLabel := "ok"
+	var cloudErr *CloudAPIError
+	if errors.As(err, &cloudErr) {
+		if cloudErr.StatusCode != 0 {
+			statusLabel = strconv.Itoa(cloudErr.StatusCode)
+		} else {
+			statusLabel = string(cloudErr.Class)
+		}
+	}
+	if retries > 0 {
+		cloudAPIRetriesTotal.WithLabelValues(op).Add(float64(retries))
+	}
+
+	cloudAPIRequestsTotal.WithLabelValues(op, statusLabel).Inc()
+	cloudAPIRequestDuration.WithLabelValues(op, statusLabel).Observe(time.Since(start).Seconds())
+}
+
+// observeVolumeStates replaces the volumes-by-state gauge with the states of volumes
+func observeVolumeStates(volumes []*NFSVolume) {
+	counts := make(map[string]int)
+	for _, vol := range volumes {
+		counts[vol.State]++
+	}
+
+	volumesByState.Reset()
+	for state, count := range counts {
+		volumesByState.WithLabelValues(state).Set(float64(count))
+	}
+}
@@ -0,0 +1,196 @@
+package driver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Volume context key carrying the NFS mount options from the StorageClass
+const volumeContextMountOptions = "mountOptions"
+
+// defaultMountOptions are the NFS mount options used unless the StorageClass
+// or the PV mount options override them
+var defaultMountOptions = []string{"nolock"}
+
+// mountParameters are the StorageClass parameters that become NFS mount
+// options, in the order they are passed to mount
+var mountParameters = []string{"nfsvers", "proto", "nconnect", "rsize", "wsize", "timeo", "retrans", "hard", "lock"}
+
+// parseMountParameters validates the NFS mount parameters of a StorageClass
+// and returns the mount options they translate to
+func parseMountParameters(params map[string]string) ([]string, error) {
+	var options []string
+	for _, name := range mountParameters {
+		value, ok := params[name]
+		if !ok {
+			continue
+		}
+
+		switch name {
+		case "nfsvers":
+			switch value {
+			case "3", "4", "4.0", "4.1", "4.2":
+			default:
+				return nil, fmt.Errorf("nfsvers must be one of 3, 4, 4.0, 4.1 or 4.2, got %q", value)
+			}
+			options = append(options, "nfsvers="+value)
+
+		case "proto":
+			switch value {
+			case "tcp", "udp":
+			default:
+				return nil, fmt.Errorf("proto must be tcp or udp, got %q", value)
+			}
+			options = append(options, "proto="+value)
+
+		case "nconnect":
+			if err := checkIntParameter(name, value, 1, 16); err != nil {
+				return nil, err
+			}
+			options = append(options, "nconnect="+value)
+
+		case "rsize", "wsize":
+			if err := checkIntParameter(name, value, 1024, 1048576); err != nil {
+				return nil, err
+			}
+			if n, _ := strconv.Atoi(value); n%1024 != 0 {
+				return nil, fmt.Errorf("%s must be a multiple of 1024, got %q", name, value)
+			}
+			options = append(options, name+"="+value)
+
+		case "timeo":
+			if err := checkIntParameter(name, value, 1, 6000); err != nil {
+				return nil, err
+			}
+			options = append(options, "timeo="+value)
+
+		case "retrans":
+			if err := checkIntParameter(name, value, 0, 100); err != nil {
+				return nil, err
+			}
+			options = append(options, "retrans="+value)
+
+		case "hard":
+			hard, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("hard must be true or false, got %q", value)
+			}
+			if hard {
+				options = append(options, "hard")
+			} else {
+				options = append(options, "soft")
+			}
+
+		case "lock":
+			lock, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("lock must be true or false, got %q", value)
+			}
+			if lock {
+				options = append(options, "lock")
+			} else {
+				options = append(options, "nolock")
+			}
+		}
+	}
+
+	if err := checkMountOptions(options); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// checkIntParameter checks that value is an integer between min and max
+func checkIntParameter(name, value string, min, max int) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return fmt.Errorf("%s must be an integer between %d and %d, got %q", name, min, max, value)
+	}
+	return nil
+}
+
+// checkMountOptions rejects a set of mount options that sets the same option
+// twice with different values, or combines options the NFS client does not support
+func checkMountOptions(options []string) error {
+	seen := make(map[string]string)
+	for _, opt := range options {
+		key := mountOptionKey(opt)
+		if prev, ok := seen[key]; ok && prev != opt {
+			return fmt.Errorf("mount options %q and %q conflict", prev, opt)
+		}
+		seen[key] = opt
+	}
+
+	vers := mountOptionValue(seen["nfsvers"])
+	proto := mountOptionValue(seen["proto"])
+	if proto == "udp" && strings.HasPrefix(vers, "4") {
+		return fmt.Errorf("NFS version %s does not support proto=udp", vers)
+	}
+	if proto == "udp" && seen["nconnect"] != "" {
+		return fmt.Errorf("nconnect requires proto=tcp")
+	}
+	return nil
+}
+
+// mergeMountOptions merges layers of mount options. An option in a later layer
+// replaces the option with the same key from an earlier layer, in place.
+func mergeMountOptions(layers ...[]string) []string {
+	var merged []string
+	index := make(map[string]int)
+	for _, layer := range layers {
+		for _, opt := range layer {
+			key := mountOptionKey(opt)
+			if i, ok := index[key]; ok {
+				merged[i] = opt
+				continue
+			}
+			index[key] = len(merged)
+			merged = append(merged, opt)
+		}
+	}
+	return merged
+}
+
+// mountOptionKey returns the name an option is merged and checked by, so that
+// aliases and opposites such as vers/nfsvers, hard/soft and lock/nolock collide
+func mountOptionKey(opt string) string {
+	name := opt
+	if i := strings.Index(opt, "="); i >= 0 {
+		name = opt[:i]
+	}
+
+	switch name {
+	case "vers":
+		return "nfsvers"
+	case "soft":
+		return "hard"
+	case "nolock":
+		return "lock"
+	case "tcp", "udp":
+		return "proto"
+	case "ro":
+		return "rw"
+	}
+	return name
+}
+
+// mountOptionValue returns the value of a name=value option, or the option
+// itself for flags such as tcp
+func mountOptionValue(opt string) string {
+	if i := strings.Index(opt, "="); i >= 0 {
+		return opt[i+1:]
+	}
+	return opt
+}
+
+// splitMountOptions splits a comma-separated list of mount options
+func splitMountOptions(options string) []string {
+	var split []string
+	for _, opt := range strings.Split(options, ",") {
+		if opt = strings.TrimSpace(opt); opt != "" {
+			split = append(split, opt)
+		}
+	}
+	return split
+}
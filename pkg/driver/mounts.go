@@ -0,0 +1,125 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"k8s.io/mount-utils"
+)
+
+// unmountTimeout is how long a regular unmount may take before it is retried
+// with force
+const unmountTimeout = 30 * time.Second
+
+// isCorruptedMount reports whether err from checking or probing a mount means
+// the mount is dead, e.g. an ESTALE handle left after the NFS server restarted
+func isCorruptedMount(err error) bool {
+	if errors.Is(err, errStatsTimeout) {
+		return true
+	}
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return mount.IsCorruptedMnt(pathErr)
+	}
+	return mount.IsCorruptedMnt(err)
+}
+
+// probeMount checks that the NFS server behind the mount at target still
+// answers, giving up after volumeStatsTimeout
+func probeMount(target string) error {
+	done := make(chan error, 1)
+	go func() {
+		var st unix.Statfs_t
+		if err := unix.Statfs(target, &st); err != nil {
+			done <- &os.PathError{Op: "statfs", Path: target, Err: err}
+			return
+		}
+		done <- nil
+	}()
+
+	timer := time.NewTimer(volumeStatsTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("failed to probe mount %s: %w", target, errStatsTimeout)
+	}
+}
+
+// forceUnmount unmounts a corrupted mount at target. The unmount is retried
+// with force after unmountTimeout, and the mount is lazily detached as a last
+// resort so that a dead NFS server can never keep it in place.
+func (d *TritonNFSDriver) forceUnmount(target string) error {
+	var err error
+	if forceUnmounter, ok := d.mounter.(mount.MounterForceUnmounter); ok {
+		err = forceUnmounter.UnmountWithForce(target, unmountTimeout)
+	} else {
+		err = d.mounter.Unmount(target)
+	}
+	if err == nil {
+		return nil
+	}
+
+	logrus.Warnf("Failed to unmount %s, detaching it lazily: %v", target, err)
+	if err := unix.Unmount(target, unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to lazily unmount %s: %v", target, err)
+	}
+	return nil
+}
+
+// cleanupMountPoint unmounts target if it is mounted, including corrupted
+// mounts, and removes the directory
+func (d *TritonNFSDriver) cleanupMountPoint(target string) error {
+	var err error
+	if forceUnmounter, ok := d.mounter.(mount.MounterForceUnmounter); ok {
+		err = mount.CleanupMountWithForce(target, forceUnmounter, false, unmountTimeout)
+	} else {
+		err = mount.CleanupMountPoint(target, d.mounter, false)
+	}
+	if err == nil {
+		return nil
+	}
+
+	// The mount may still be held by a dead server, detach it and retry the cleanup
+	logrus.Warnf("Failed to clean up mount point %s, detaching it lazily: %v", target, err)
+	if err := unix.Unmount(target, unix.MNT_DETACH); err != nil && !errors.Is(err, unix.EINVAL) {
+		return fmt.Errorf("failed to lazily unmount %s: %v", target, err)
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove directory %s: %v", target, err)
+	}
+	return nil
+}
+
+// withVolumeMounted mounts the share of volume on a temporary directory on the
+// controller, runs fn with that directory, and unmounts it again
+func (d *TritonNFSDriver) withVolumeMounted(volume *NFSVolume, mountOptions []string, fn func(dir string) error) error {
+	nfsPath, err := volumeNFSPath(volume)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "tritonnfs-volume-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary mount point: %v", err)
+	}
+
+	options := mergeMountOptions(defaultMountOptions, nfsPath.MountOptions(), mountOptions)
+	if err := d.mounter.Mount(nfsPath.Source(), dir, "nfs", options); err != nil {
+		os.Remove(dir)
+		return fmt.Errorf("failed to mount volume %s: %v", volume.Name, err)
+	}
+	defer func() {
+		if err := d.cleanupMountPoint(dir); err != nil {
+			logrus.Errorf("Failed to unmount volume %s from %s: %v", volume.Name, dir, err)
+		}
+	}()
+
+	return fn(dir)
+}
@@ -0,0 +1,206 @@
+package driver
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StorageClass parameters selecting the networks of a volume
+const (
+	// paramNetworks is a comma-separated list of network names or IDs to
+	// attach the volume to
+	paramNetworks = "networks"
+
+	// paramMountNetwork is the network name or ID the nodes mount the volume
+	// through. The volume is attached to it first, so that Triton serves the
+	// volume on it.
+	paramMountNetwork = "mountNetwork"
+)
+
+// tagMountNetwork holds the ID of the network a volume is mounted through
+const tagMountNetwork = "mount-network"
+
+// uuidPattern matches Triton UUIDs
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// resolveNetwork returns the ID of the network with the given name or ID.
+// A UUID that is not listed is passed through for CloudAPI to check, since
+// not every network a volume can use is listed.
+func resolveNetwork(networks []*Network, nameOrID string) (string, error) {
+	var matches []*Network
+	for _, network := range networks {
+		if network.ID == nameOrID {
+			return network.ID, nil
+		}
+		if network.Name == nameOrID {
+			matches = append(matches, network)
+		}
+	}
+
+	switch {
+	case len(matches) == 1:
+		return matches[0].ID, nil
+	case len(matches) > 1:
+		return "", status.Errorf(codes.InvalidArgument, "Network name %q is ambiguous, use one of the IDs instead", nameOrID)
+	case uuidPattern.MatchString(nameOrID):
+		return nameOrID, nil
+	}
+	return "", status.Errorf(codes.InvalidArgument, "Network %q not found", nameOrID)
+}
+
+// hasNetwork returns whether a network ID is in a network list
+func hasNetwork(networks []*Network, id string) bool {
+	for _, network := range networks {
+		if network.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeNetworks resolves the networks and mountNetwork parameters to the
+// network IDs to create a volume with, and the ID of the mount network. The
+// mount network is picked from the accessibility requirements when they have
+// a network segment, and is always the first network of the volume.
+func (d *TritonNFSDriver) volumeNetworks(ctx context.Context, params map[string]string, requirements *csi.TopologyRequirement) ([]string, string, error) {
+	var names []string
+	for _, name := range strings.Split(params[paramNetworks], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	mountNetwork := strings.TrimSpace(params[paramMountNetwork])
+
+	// Only list networks when there is a name to resolve or a mount network
+	var networks []*Network
+	listed := false
+	listNetworks := func() error {
+		if listed {
+			return nil
+		}
+		var err error
+		networks, err = d.volumeBackend(ctx).ListNetworks(ctx)
+		if err != nil {
+			return cloudAPIStatus(err, "Failed to list networks")
+		}
+		listed = true
+		return nil
+	}
+	needsList := mountNetwork != ""
+	for _, name := range names {
+		needsList = needsList || !uuidPattern.MatchString(name)
+	}
+	if needsList {
+		if err := listNetworks(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	var ids []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		id, err := resolveNetwork(networks, name)
+		if err != nil {
+			return nil, "", err
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	var mountNetworkID string
+	if mountNetwork != "" {
+		var err error
+		mountNetworkID, err = resolveNetwork(networks, mountNetwork)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	mountNetworkID, err := d.topologyNetwork(ctx, requirements, mountNetworkID)
+	if err != nil {
+		return nil, "", err
+	}
+	if mountNetworkID == "" {
+		return ids, "", nil
+	}
+
+	// The NFS server of a volume is found on its mount network through the
+	// network list, so the mount network must be listed
+	if err := listNetworks(); err != nil {
+		return nil, "", err
+	}
+	if !hasNetwork(networks, mountNetworkID) {
+		return nil, "", status.Errorf(codes.InvalidArgument, "Mount network %s is not in the networks of the account", mountNetworkID)
+	}
+
+	ordered := []string{mountNetworkID}
+	for _, id := range ids {
+		if id != mountNetworkID {
+			ordered = append(ordered, id)
+		}
+	}
+	return ordered, mountNetworkID, nil
+}
+
+// describeNetworks fills in the names and subnets of the networks of a
+// volume, and the NFS server address on the network whose subnet holds the
+// server of the volume's filesystem_path
+func describeNetworks(volume *NFSVolume, networks []*Network) {
+	byID := make(map[string]*Network, len(networks))
+	for _, network := range networks {
+		byID[network.ID] = network
+	}
+
+	var server net.IP
+	if nfsPath, err := ParseNFSPath(volume.FileSystemPath); err == nil {
+		server = net.ParseIP(nfsPath.Host)
+	}
+
+	for i := range volume.Networks {
+		network, ok := byID[volume.Networks[i].ID]
+		if !ok {
+			continue
+		}
+		volume.Networks[i].Name = network.Name
+		volume.Networks[i].Subnet = network.Subnet
+		if _, subnet, err := net.ParseCIDR(network.Subnet); err == nil && server != nil && subnet.Contains(server) {
+			volume.Networks[i].IP = server.String()
+		}
+	}
+}
+
+// volumeNFSPath returns the NFS path to mount a volume from, the one of its
+// filesystem_path. A volume with a mount network must be served on it.
+func volumeNFSPath(volume *NFSVolume) (*NFSPath, error) {
+	nfsPath, err := ParseNFSPath(volume.FileSystemPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMountNetwork(volume); err != nil {
+		return nil, err
+	}
+	return nfsPath, nil
+}
+
+// checkMountNetwork checks that the NFS server of a volume is on its mount
+// network. Triton serves a volume only at the address in its filesystem_path,
+// and CloudAPI reports no address on the other networks of the volume.
+func checkMountNetwork(volume *NFSVolume) error {
+	networkID := volume.Tags[tagMountNetwork]
+	if networkID == "" {
+		return nil
+	}
+	for _, network := range volume.Networks {
+		if network.ID == networkID && network.IP != "" {
+			return nil
+		}
+	}
+	return status.Errorf(codes.FailedPrecondition, "Volume %s is served at %s, which is not on its mount network %s", volume.Name, volume.FileSystemPath, networkID)
+}
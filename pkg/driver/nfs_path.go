@@ -0,0 +1,139 @@
+package driver
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// NFSPath is a parsed Triton filesystem_path, e.g. "192.168.128.10:/exports/data",
+// "[fd00::10]:/exports/data" or "nfs.example.com:2050:/exports/data"
+type NFSPath struct {
+	// Host is the NFS server IP address or hostname, without brackets
+	Host string
+
+	// Port is the NFS server port, or empty for the default port
+	Port string
+
+	// Share is the exported path on the server
+	Share string
+}
+
+// ParseNFSPath parses a Triton filesystem_path of the form host[:port]:/share.
+// IPv6 addresses may be bracketed, and must be when a port is given.
+func ParseNFSPath(path string) (*NFSPath, error) {
+	i := strings.Index(path, ":/")
+	if strings.HasPrefix(path, "[") {
+		// Skip past the address so a "::/" inside it is not taken as the separator
+		end := strings.Index(path, "]")
+		if end < 0 {
+			return nil, fmt.Errorf("invalid NFS path %q: missing closing bracket", path)
+		}
+		if j := strings.Index(path[end:], ":/"); j >= 0 {
+			i = end + j
+		} else {
+			i = -1
+		}
+	} else if slash := strings.Index(path, "/"); slash > 0 && path[slash-1] == ':' {
+		// The share starts at the first slash, which is never part of the address
+		i = slash - 1
+	}
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid NFS path %q: expected host:/share", path)
+	}
+
+	host, port, err := ParseNFSServer(path[:i])
+	if err != nil {
+		return nil, fmt.Errorf("invalid NFS path %q: %v", path, err)
+	}
+
+	return &NFSPath{
+		Host:  host,
+		Port:  port,
+		Share: path[i+1:],
+	}, nil
+}
+
+// ParseNFSServer parses an NFS server address of the form host, host:port,
+// an IPv6 address, or a bracketed IPv6 address with an optional port. The
+// returned port is empty when none was given.
+func ParseNFSServer(server string) (string, string, error) {
+	var host, port string
+	switch {
+	case server == "":
+		return "", "", fmt.Errorf("empty server address")
+
+	case strings.HasPrefix(server, "["):
+		end := strings.Index(server, "]")
+		if end < 0 {
+			return "", "", fmt.Errorf("missing closing bracket in %q", server)
+		}
+		host = server[1:end]
+		rest := server[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return "", "", fmt.Errorf("unexpected %q after address in %q", rest, server)
+			}
+			port = rest[1:]
+			if port == "" {
+				return "", "", fmt.Errorf("empty port in %q", server)
+			}
+		}
+		if net.ParseIP(host) == nil || !strings.Contains(host, ":") {
+			return "", "", fmt.Errorf("invalid IPv6 address %q", host)
+		}
+
+	case strings.Count(server, ":") > 1:
+		// An IPv6 address without brackets cannot carry a port
+		host = server
+		if net.ParseIP(host) == nil {
+			return "", "", fmt.Errorf("invalid IPv6 address %q", host)
+		}
+
+	case strings.Contains(server, ":"):
+		host, port, _ = strings.Cut(server, ":")
+		if port == "" {
+			return "", "", fmt.Errorf("empty port in %q", server)
+		}
+
+	default:
+		host = server
+	}
+
+	if host == "" {
+		return "", "", fmt.Errorf("empty host in %q", server)
+	}
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", "", fmt.Errorf("invalid port %q in %q", port, server)
+		}
+	}
+	return host, port, nil
+}
+
+// Server returns the server address for the volume context: the host,
+// followed by the port when it is not the default NFS port
+func (p *NFSPath) Server() string {
+	if p.Port == "" || p.Port == NFSDefaultPort {
+		return p.Host
+	}
+	return net.JoinHostPort(p.Host, p.Port)
+}
+
+// Source returns the source argument for mount, with IPv6 addresses bracketed.
+// The port is not part of the source, it is passed with the port= mount option.
+func (p *NFSPath) Source() string {
+	if strings.Contains(p.Host, ":") {
+		return fmt.Sprintf("[%s]:%s", p.Host, p.Share)
+	}
+	return fmt.Sprintf("%s:%s", p.Host, p.Share)
+}
+
+// MountOptions returns the mount options needed to reach the server
+func (p *NFSPath) MountOptions() []string {
+	if p.Port == "" || p.Port == NFSDefaultPort {
+		return nil
+	}
+	return []string{"port=" + p.Port}
+}
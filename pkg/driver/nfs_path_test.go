@@ -0,0 +1,167 @@
+package driver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNFSPath(t *testing.T) {
+	tests := []struct {
+		path         string
+		want         *NFSPath
+		server       string
+		source       string
+		mountOptions []string
+	}{
+		{
+			path:   "192.168.128.10:/exports/data",
+			want:   &NFSPath{Host: "192.168.128.10", Share: "/exports/data"},
+			server: "192.168.128.10",
+			source: "192.168.128.10:/exports/data",
+		},
+		{
+			path:   "nfs.example.com:/exports/data",
+			want:   &NFSPath{Host: "nfs.example.com", Share: "/exports/data"},
+			server: "nfs.example.com",
+			source: "nfs.example.com:/exports/data",
+		},
+		{
+			path:         "nfs.example.com:2050:/exports/data",
+			want:         &NFSPath{Host: "nfs.example.com", Port: "2050", Share: "/exports/data"},
+			server:       "nfs.example.com:2050",
+			source:       "nfs.example.com:/exports/data",
+			mountOptions: []string{"port=2050"},
+		},
+		{
+			path:   "192.168.128.10:2049:/exports/data",
+			want:   &NFSPath{Host: "192.168.128.10", Port: "2049", Share: "/exports/data"},
+			server: "192.168.128.10",
+			source: "192.168.128.10:/exports/data",
+		},
+		{
+			path:   "[fd00::10]:/exports/data",
+			want:   &NFSPath{Host: "fd00::10", Share: "/exports/data"},
+			server: "fd00::10",
+			source: "[fd00::10]:/exports/data",
+		},
+		{
+			path:         "[fd00::10]:2050:/exports/data",
+			want:         &NFSPath{Host: "fd00::10", Port: "2050", Share: "/exports/data"},
+			server:       "[fd00::10]:2050",
+			source:       "[fd00::10]:/exports/data",
+			mountOptions: []string{"port=2050"},
+		},
+		{
+			// The "::/" inside the bracketed address is not the share separator
+			path:   "[fd00::]:/exports/data",
+			want:   &NFSPath{Host: "fd00::", Share: "/exports/data"},
+			server: "fd00::",
+			source: "[fd00::]:/exports/data",
+		},
+		{
+			path:   "fd00::10:/exports/data",
+			want:   &NFSPath{Host: "fd00::10", Share: "/exports/data"},
+			server: "fd00::10",
+			source: "[fd00::10]:/exports/data",
+		},
+		{
+			path:   "192.168.128.10:/exports/data/",
+			want:   &NFSPath{Host: "192.168.128.10", Share: "/exports/data/"},
+			server: "192.168.128.10",
+			source: "192.168.128.10:/exports/data/",
+		},
+		{
+			path:   "192.168.128.10:/",
+			want:   &NFSPath{Host: "192.168.128.10", Share: "/"},
+			server: "192.168.128.10",
+			source: "192.168.128.10:/",
+		},
+		{path: ""},
+		{path: "192.168.128.10"},
+		{path: "192.168.128.10:"},
+		{path: "192.168.128.10:exports/data"},
+		{path: ":/exports/data"},
+		{path: "/exports/data"},
+		{path: "[fd00::10:/exports/data"},
+		{path: "[fd00::10]x:/exports/data"},
+		{path: "[192.168.128.10]:/exports/data"},
+		{path: "nfs.example.com:0:/exports/data"},
+		{path: "nfs.example.com:nfs:/exports/data"},
+		{path: "[fd00::10]:70000:/exports/data"},
+		{path: "fd00:::10:/exports/data"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := ParseNFSPath(tt.path)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseNFSPath: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+			if server := got.Server(); server != tt.server {
+				t.Errorf("expected server %q, got %q", tt.server, server)
+			}
+			if source := got.Source(); source != tt.source {
+				t.Errorf("expected source %q, got %q", tt.source, source)
+			}
+			if options := got.MountOptions(); !reflect.DeepEqual(options, tt.mountOptions) {
+				t.Errorf("expected mount options %v, got %v", tt.mountOptions, options)
+			}
+		})
+	}
+}
+
+func TestParseNFSServer(t *testing.T) {
+	tests := []struct {
+		server  string
+		host    string
+		port    string
+		invalid bool
+	}{
+		{server: "192.168.128.10", host: "192.168.128.10"},
+		{server: "192.168.128.10:2050", host: "192.168.128.10", port: "2050"},
+		{server: "nfs.example.com", host: "nfs.example.com"},
+		{server: "nfs.example.com:2049", host: "nfs.example.com", port: "2049"},
+		{server: "fd00::10", host: "fd00::10"},
+		{server: "fd00::", host: "fd00::"},
+		{server: "[fd00::10]", host: "fd00::10"},
+		{server: "[fd00::10]:2050", host: "fd00::10", port: "2050"},
+		{server: "", invalid: true},
+		{server: ":2050", invalid: true},
+		{server: "nfs.example.com:", invalid: true},
+		{server: "[fd00::10]:", invalid: true},
+		{server: "nfs.example.com:65536", invalid: true},
+		{server: "nfs.example.com:-1", invalid: true},
+		{server: "[fd00::10", invalid: true},
+		{server: "[fd00::10]2050", invalid: true},
+		{server: "[]:2050", invalid: true},
+		{server: "[nfs.example.com]", invalid: true},
+		{server: "fd00::10::1", invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.server, func(t *testing.T) {
+			host, port, err := ParseNFSServer(tt.server)
+			if tt.invalid {
+				if err == nil {
+					t.Fatalf("expected an error, got host %q and port %q", host, port)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseNFSServer: %v", err)
+			}
+			if host != tt.host || port != tt.port {
+				t.Errorf("expected host %q and port %q, got %q and %q", tt.host, tt.port, host, port)
+			}
+		})
+	}
+}
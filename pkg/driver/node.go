@@ -2,8 +2,10 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path"
 	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -17,24 +19,107 @@ const (
 	NFSDefaultPort = "2049"
 )
 
-// NodeStageVolume stages a volume on the node
+// NodeStageVolume mounts the NFS share of a volume once per node at the
+// staging path. NodePublishVolume bind-mounts it into each pod.
 func (d *TritonNFSDriver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
-	// NFS volumes don't require staging
+	// Validate arguments
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID must be provided")
+	}
+	if req.GetStagingTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Staging target path must be provided")
+	}
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability must be provided")
+	}
+
+	// Reject concurrent operations on the same staging path
+	release, err := d.lockOperation(lockPrefixTargetPath + req.GetStagingTargetPath())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := d.stageVolume(req.GetVolumeId(), req.GetStagingTargetPath(), req.GetVolumeContext(), req.GetVolumeCapability()); err != nil {
+		return nil, err
+	}
+
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
-// NodeUnstageVolume unstages a volume from the node
+// stageVolume mounts the NFS share of a volume at stagingPath unless a
+// healthy mount is already staged there. A corrupted or stale staged mount is
+// unmounted and mounted again. Callers must hold the staging path lock.
+func (d *TritonNFSDriver) stageVolume(volumeID, stagingPath string, volumeContext map[string]string, capability *csi.VolumeCapability) error {
+	// If a healthy mount is already staged, return
+	mounted, err := d.prepareMountPoint(stagingPath)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		return nil
+	}
+
+	// Get the NFS source and mount options
+	source, mountOptions, err := nfsMountSource(volumeContext, capability)
+	if err != nil {
+		return err
+	}
+
+	// Mount the volume
+	logrus.Infof("Staging NFS volume %s from %s to %s with options %v", volumeID, source, stagingPath, mountOptions)
+	if err := d.mounter.Mount(source, stagingPath, "nfs", mountOptions); err != nil {
+		return status.Errorf(codes.Internal, "Failed to mount volume %s to %s: %v", source, stagingPath, err)
+	}
+	return nil
+}
+
+// NodeUnstageVolume unmounts the NFS share of a volume from the staging path
+// once no pod target is bind-mounted from it any more
 func (d *TritonNFSDriver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
-	// NFS volumes don't require unstaging
+	// Validate arguments
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID must be provided")
+	}
+	if req.GetStagingTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Staging target path must be provided")
+	}
+
+	// Reject concurrent operations on the same staging path
+	release, err := d.lockOperation(lockPrefixTargetPath + req.GetStagingTargetPath())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Keep the staged mount while pods still use it
+	stagingPath := req.GetStagingTargetPath()
+	refs, err := d.mounter.GetMountRefs(stagingPath)
+	if err != nil && !os.IsNotExist(err) && !isCorruptedMount(err) {
+		return nil, status.Errorf(codes.Internal, "Failed to find mounts of %s: %v", stagingPath, err)
+	}
+	if len(refs) > 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "Volume %s is still published at %s", req.GetVolumeId(), strings.Join(refs, ", "))
+	}
+
+	// Unmount the volume and remove the directory
+	logrus.Infof("Unstaging volume %s from %s", req.GetVolumeId(), stagingPath)
+	if err := d.cleanupMountPoint(stagingPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to unmount volume: %v", err)
+	}
+
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
-// NodePublishVolume mounts a volume to the target path
+// NodePublishVolume bind-mounts the staged volume to the target path
 func (d *TritonNFSDriver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
 	// Validate arguments
 	if req.GetVolumeId() == "" {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID must be provided")
 	}
+	if req.GetStagingTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Staging target path must be provided")
+	}
 	if req.GetTargetPath() == "" {
 		return nil, status.Error(codes.InvalidArgument, "Target path must be provided")
 	}
@@ -42,63 +127,62 @@ func (d *TritonNFSDriver) NodePublishVolume(ctx context.Context, req *csi.NodePu
 		return nil, status.Error(codes.InvalidArgument, "Volume capability must be provided")
 	}
 
-	// Check if the target path exists
-	targetPath := req.GetTargetPath()
-	notMount, err := d.mounter.IsLikelyNotMountPoint(targetPath)
+	// Reject concurrent operations on the same target path
+	release, err := d.lockOperation(lockPrefixTargetPath + req.GetTargetPath())
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Create the directory if it doesn't exist
-			if err := os.MkdirAll(targetPath, 0750); err != nil {
-				return nil, status.Errorf(codes.Internal, "Failed to create directory %s: %v", targetPath, err)
-			}
-			notMount = true
-		} else {
-			return nil, status.Errorf(codes.Internal, "Failed to check mount point: %v", err)
-		}
+		return nil, err
 	}
+	defer release()
 
-	// If already mounted, return
-	if !notMount {
-		return &csi.NodePublishVolumeResponse{}, nil
+	// If a healthy mount is already in place, return
+	targetPath := req.GetTargetPath()
+	mounted, err := d.prepareMountPoint(targetPath)
+	if err != nil {
+		return nil, err
 	}
-
-	// Get the volume context
-	volumeContext := req.GetVolumeContext()
-	server, ok := volumeContext["server"]
-	if !ok || server == "" {
-		return nil, status.Error(codes.InvalidArgument, "server must be provided in volume context")
+	if mounted {
+		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
-	share, ok := volumeContext["share"]
-	if !ok || share == "" {
-		return nil, status.Error(codes.InvalidArgument, "share must be provided in volume context")
+	// Kubelet does not stage the volume again when its NFS mount goes stale,
+	// for example after the Triton NFS server zone restarted, or is gone, for
+	// example after a reboot or a manual unmount, so stage it again here
+	// rather than binding a dead share or the bare staging directory into
+	// the pod
+	stagingPath := req.GetStagingTargetPath()
+	notMounted, err := d.mounter.IsLikelyNotMountPoint(stagingPath)
+	if err == nil && !notMounted {
+		err = probeMount(stagingPath)
 	}
-
-	// Add default port if not present
-	if !strings.Contains(server, ":") {
-		server = server + ":" + NFSDefaultPort
+	if err != nil && !os.IsNotExist(err) && !isCorruptedMount(err) {
+		return nil, status.Errorf(codes.FailedPrecondition, "Volume %s is not staged at %s: %v", req.GetVolumeId(), stagingPath, err)
 	}
+	if err != nil || notMounted {
+		if err != nil {
+			logrus.Warnf("Staged mount of volume %s at %s is stale or gone, staging it again: %v", req.GetVolumeId(), stagingPath, err)
+		} else {
+			logrus.Warnf("Volume %s is not mounted at %s, staging it again", req.GetVolumeId(), stagingPath)
+		}
 
-	// Get mount options from volume capability
-	mountOptions := []string{"nolock"}
-	if mount := req.GetVolumeCapability().GetMount(); mount != nil {
-		for _, opt := range mount.GetMountFlags() {
-			mountOptions = append(mountOptions, opt)
+		releaseStaging, err := d.lockOperation(lockPrefixTargetPath + stagingPath)
+		if err != nil {
+			return nil, err
+		}
+		defer releaseStaging()
+		if err := d.stageVolume(req.GetVolumeId(), stagingPath, req.GetVolumeContext(), req.GetVolumeCapability()); err != nil {
+			return nil, err
 		}
 	}
 
-	// Add readonly option if specified
+	// Bind-mount the staged volume, read-only if requested
+	mountOptions := []string{"bind"}
 	if req.GetReadonly() {
 		mountOptions = append(mountOptions, "ro")
 	}
 
-	// Format the source
-	source := fmt.Sprintf("%s:%s", server, share)
-
-	// Mount the volume
-	logrus.Infof("Mounting NFS volume %s from %s to %s with options %v", req.GetVolumeId(), source, targetPath, mountOptions)
-	if err := d.mounter.Mount(source, targetPath, "nfs", mountOptions); err != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to mount volume %s to %s: %v", source, targetPath, err)
+	logrus.Infof("Publishing volume %s from %s to %s with options %v", req.GetVolumeId(), stagingPath, targetPath, mountOptions)
+	if err := d.mounter.Mount(stagingPath, targetPath, "", mountOptions); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to bind mount volume %s to %s: %v", stagingPath, targetPath, err)
 	}
 
 	return &csi.NodePublishVolumeResponse{}, nil
@@ -114,28 +198,19 @@ func (d *TritonNFSDriver) NodeUnpublishVolume(ctx context.Context, req *csi.Node
 		return nil, status.Error(codes.InvalidArgument, "Target path must be provided")
 	}
 
-	// Check if the target path exists
-	targetPath := req.GetTargetPath()
-	notMount, err := d.mounter.IsLikelyNotMountPoint(targetPath)
+	// Reject concurrent operations on the same target path
+	release, err := d.lockOperation(lockPrefixTargetPath + req.GetTargetPath())
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Already unmounted
-			return &csi.NodeUnpublishVolumeResponse{}, nil
-		}
-		return nil, status.Errorf(codes.Internal, "Failed to check mount point: %v", err)
+		return nil, err
 	}
+	defer release()
 
-	// Unmount if mounted
-	if !notMount {
-		logrus.Infof("Unmounting volume %s from %s", req.GetVolumeId(), targetPath)
-		if err := d.mounter.Unmount(targetPath); err != nil {
-			return nil, status.Errorf(codes.Internal, "Failed to unmount volume: %v", err)
-		}
-	}
-
-	// Remove the directory
-	if err := os.Remove(targetPath); err != nil && !os.IsNotExist(err) {
-		return nil, status.Errorf(codes.Internal, "Failed to remove directory %s: %v", targetPath, err)
+	// Unmount the volume if it is mounted and remove the directory. Corrupted
+	// mounts are unmounted with force so that cleanup never gets stuck.
+	targetPath := req.GetTargetPath()
+	logrus.Infof("Unmounting volume %s from %s", req.GetVolumeId(), targetPath)
+	if err := d.cleanupMountPoint(targetPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to unmount volume: %v", err)
 	}
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
@@ -145,6 +220,13 @@ func (d *TritonNFSDriver) NodeUnpublishVolume(ctx context.Context, req *csi.Node
 func (d *TritonNFSDriver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
 	return &csi.NodeGetCapabilitiesResponse{
 		Capabilities: []*csi.NodeServiceCapability{
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+					},
+				},
+			},
 			{
 				Type: &csi.NodeServiceCapability_Rpc{
 					Rpc: &csi.NodeServiceCapability_RPC{
@@ -152,6 +234,13 @@ func (d *TritonNFSDriver) NodeGetCapabilities(ctx context.Context, req *csi.Node
 					},
 				},
 			},
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+					},
+				},
+			},
 		},
 	}, nil
 }
@@ -159,7 +248,8 @@ func (d *TritonNFSDriver) NodeGetCapabilities(ctx context.Context, req *csi.Node
 // NodeGetInfo returns info about the node
 func (d *TritonNFSDriver) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
 	return &csi.NodeGetInfoResponse{
-		NodeId: d.nodeID,
+		NodeId:             d.nodeID,
+		AccessibleTopology: d.nodeTopology(),
 	}, nil
 }
 
@@ -173,22 +263,157 @@ func (d *TritonNFSDriver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeG
 		return nil, status.Error(codes.InvalidArgument, "Volume path must be provided")
 	}
 
-	// Check if volume path exists
+	// Get the filesystem usage of the mounted volume
 	volumePath := req.GetVolumePath()
-	_, err := os.Stat(volumePath)
+	stats, err := d.statVolume(ctx, volumePath)
 	if err != nil {
-		if os.IsNotExist(err) {
+		// A stale or unresponsive NFS mount is reported as an abnormal
+		// volume condition rather than an error, so kubelet can surface it
+		if isUnhealthyMountError(err) {
+			logrus.Warnf("Volume %s at %s is unhealthy: %v", req.GetVolumeId(), volumePath, err)
+			return &csi.NodeGetVolumeStatsResponse{
+				VolumeCondition: &csi.VolumeCondition{
+					Abnormal: true,
+					Message:  fmt.Sprintf("NFS mount is unhealthy: %v", err),
+				},
+			}, nil
+		}
+
+		switch {
+		case os.IsNotExist(err):
 			return nil, status.Errorf(codes.NotFound, "Volume path %s does not exist", volumePath)
+		case errors.Is(err, errNotMountPoint):
+			return nil, status.Errorf(codes.NotFound, "Volume path %s is not mounted", volumePath)
+		case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+			return nil, status.FromContextError(err).Err()
 		}
-		return nil, status.Errorf(codes.Internal, "Failed to stat volume path: %v", err)
+		return nil, status.Errorf(codes.Internal, "Failed to get stats of volume path %s: %v", volumePath, err)
 	}
 
-	// For NFS, we can't get accurate stats, so we just return that the volume exists
-	return &csi.NodeGetVolumeStatsResponse{}, nil
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{
+				Unit:      csi.VolumeUsage_BYTES,
+				Total:     stats.TotalBytes,
+				Available: stats.AvailableBytes,
+				Used:      stats.UsedBytes,
+			},
+			{
+				Unit:      csi.VolumeUsage_INODES,
+				Total:     stats.TotalInodes,
+				Available: stats.FreeInodes,
+				Used:      stats.UsedInodes,
+			},
+		},
+		VolumeCondition: &csi.VolumeCondition{
+			Abnormal: false,
+			Message:  "NFS mount is healthy",
+		},
+	}, nil
 }
 
 // NodeExpandVolume expands a volume on the node
 func (d *TritonNFSDriver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
 	// NFS volumes don't require node expansion
 	return &csi.NodeExpandVolumeResponse{}, nil
-}
\ No newline at end of file
+}
+
+// prepareMountPoint makes sure path can be mounted on. It creates the
+// directory if it is missing, and unmounts a corrupted or stale mount left
+// at path. It reports whether a healthy mount is already in place.
+func (d *TritonNFSDriver) prepareMountPoint(path string) (bool, error) {
+	notMount, err := d.mounter.IsLikelyNotMountPoint(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Create the directory if it doesn't exist
+			if err := os.MkdirAll(path, 0750); err != nil {
+				return false, status.Errorf(codes.Internal, "Failed to create directory %s: %v", path, err)
+			}
+			return false, nil
+		}
+		if !isCorruptedMount(err) {
+			return false, status.Errorf(codes.Internal, "Failed to check mount point: %v", err)
+		}
+
+		// A dead mount is still in place, unmount it and mount again
+		logrus.Warnf("Path %s has a corrupted mount, remounting: %v", path, err)
+		if err := d.forceUnmount(path); err != nil {
+			return false, status.Errorf(codes.Internal, "Failed to unmount corrupted mount at %s: %v", path, err)
+		}
+		return false, nil
+	}
+	if notMount {
+		return false, nil
+	}
+
+	// Make sure the NFS server still answers before reporting the existing
+	// mount as healthy, and unmount a stale mount
+	err = probeMount(path)
+	if err == nil {
+		return true, nil
+	}
+	if !isCorruptedMount(err) {
+		return false, status.Errorf(codes.Internal, "Failed to check existing mount at %s: %v", path, err)
+	}
+
+	logrus.Warnf("Existing mount at %s is stale, remounting: %v", path, err)
+	if err := d.forceUnmount(path); err != nil {
+		return false, status.Errorf(codes.Internal, "Failed to unmount stale mount at %s: %v", path, err)
+	}
+	return false, nil
+}
+
+// nfsMountSource returns the NFS mount source and mount options of a volume
+// from its volume context and capability
+func nfsMountSource(volumeContext map[string]string, capability *csi.VolumeCapability) (string, []string, error) {
+	server, ok := volumeContext["server"]
+	if !ok || server == "" {
+		return "", nil, status.Error(codes.InvalidArgument, "server must be provided in volume context")
+	}
+
+	share, ok := volumeContext["share"]
+	if !ok || share == "" {
+		return "", nil, status.Error(codes.InvalidArgument, "share must be provided in volume context")
+	}
+
+	// Parse the server address, which may be an IPv6 address and may carry
+	// a port other than the default
+	host, port, err := ParseNFSServer(server)
+	if err != nil {
+		return "", nil, status.Errorf(codes.InvalidArgument, "Invalid server %q in volume context: %v", server, err)
+	}
+
+	// Volumes provisioned by older releases carry the whole filesystem_path
+	// as the share
+	if !strings.HasPrefix(share, "/") {
+		nfsPath, err := ParseNFSPath(share)
+		if err != nil {
+			return "", nil, status.Errorf(codes.InvalidArgument, "Invalid share %q in volume context: %v", share, err)
+		}
+		share = nfsPath.Share
+	}
+
+	// Subdirectory volumes mount their directory of the parent share
+	if subDir := volumeContext[volumeContextSubDir]; subDir != "" {
+		if !isValidSubDir(subDir) {
+			return "", nil, status.Errorf(codes.InvalidArgument, "Invalid subdirectory %q in volume context", subDir)
+		}
+		share = path.Join(share, subDir)
+	}
+	nfsPath := &NFSPath{Host: host, Port: port, Share: share}
+
+	// Merge the mount options. The PV mount options take precedence over the
+	// StorageClass parameters in the volume context, which take precedence
+	// over the server port and the driver defaults.
+	mountFlags := capability.GetMount().GetMountFlags()
+	if err := checkMountOptions(mountFlags); err != nil {
+		return "", nil, status.Errorf(codes.InvalidArgument, "Invalid mount options: %v", err)
+	}
+	contextOptions := splitMountOptions(volumeContext[volumeContextMountOptions])
+	mountOptions := mergeMountOptions(defaultMountOptions, nfsPath.MountOptions(), contextOptions, mountFlags)
+	if err := checkMountOptions(mountOptions); err != nil {
+		return "", nil, status.Errorf(codes.InvalidArgument, "Invalid mount options: %v", err)
+	}
+
+	return nfsPath.Source(), mountOptions, nil
+}
@@ -0,0 +1,67 @@
+package driver
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	mount "k8s.io/mount-utils"
+)
+
+func TestNodePublishVolumeStagesAgain(t *testing.T) {
+	d, err := NewTritonNFSDriver(WithMode(ModeNode), WithNodeID("node-1"))
+	if err != nil {
+		t.Fatalf("NewTritonNFSDriver: %v", err)
+	}
+	mounter := mount.NewFakeMounter(nil)
+	d.mounter = mounter
+
+	// The staging directory is there, but nothing is mounted on it, as after
+	// a reboot or a manual unmount
+	dir := t.TempDir()
+	stagingPath := filepath.Join(dir, "staging")
+	targetPath := filepath.Join(dir, "target")
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:          "volume-1",
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		},
+		VolumeContext: map[string]string{"server": FakeBackendServer, "share": "/exports/volume-1"},
+	}
+	if _, err := d.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          req.VolumeId,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  req.VolumeCapability,
+		VolumeContext:     req.VolumeContext,
+	}); err != nil {
+		t.Fatalf("NodeStageVolume: %v", err)
+	}
+	if err := mounter.Unmount(stagingPath); err != nil {
+		t.Fatalf("Unmount: %v", err)
+	}
+	mounter.ResetLog()
+
+	if _, err := d.NodePublishVolume(context.Background(), req); err != nil {
+		t.Fatalf("NodePublishVolume: %v", err)
+	}
+	// The fake mounter records the device of the staged mount as the source
+	// of the bind mount
+	source := FakeBackendServer + ":/exports/volume-1"
+	expected := []mount.FakeAction{
+		{Action: mount.FakeActionMount, Target: stagingPath, Source: source, FSType: "nfs"},
+		{Action: mount.FakeActionMount, Target: targetPath, Source: source},
+	}
+	log := mounter.GetLog()
+	if len(log) != len(expected) {
+		t.Fatalf("expected mounts %+v, got %+v", expected, log)
+	}
+	for i := range expected {
+		if log[i] != expected[i] {
+			t.Errorf("expected mount %+v, got %+v", expected[i], log[i])
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Tags that mark a Triton volume as owned by the driver
+const (
+	// tagCreatedBy marks volumes created by the driver
+	tagCreatedBy = "created-by"
+
+	// tagClusterID holds the --cluster-id of the driver that created the volume
+	tagClusterID = "cluster-id"
+
+	// createdByDriver is the value of the created-by tag
+	createdByDriver = "tritonnfs-csi-driver"
+)
+
+// paramAdoptExisting lets CreateVolume and CreateSnapshot return a volume
+// with the requested name that the driver does not own
+const paramAdoptExisting = "adoptExistingVolume"
+
+// ownershipTags returns the tags the driver stamps on every volume it creates
+func (d *TritonNFSDriver) ownershipTags() map[string]string {
+	tags := map[string]string{
+		tagCreatedBy: createdByDriver,
+	}
+	if d.clusterID != "" {
+		tags[tagClusterID] = d.clusterID
+	}
+	return tags
+}
+
+// ownsVolume reports whether a volume was created by this driver for this
+// cluster. Without a cluster ID, the driver owns the volumes it created
+// that carry no cluster ID.
+func (d *TritonNFSDriver) ownsVolume(volume *NFSVolume) bool {
+	return volume.Tags[tagCreatedBy] == createdByDriver && volume.Tags[tagClusterID] == d.clusterID
+}
+
+// ownedVolumes returns the volumes owned by this driver
+func (d *TritonNFSDriver) ownedVolumes(volumes []*NFSVolume) []*NFSVolume {
+	var owned []*NFSVolume
+	for _, vol := range volumes {
+		if d.ownsVolume(vol) {
+			owned = append(owned, vol)
+		}
+	}
+	return owned
+}
+
+// findVolumeByName returns the volume named name, or nil if there is none. A
+// volume the driver does not own is only returned when adopt is set, and
+// makes the request fail with AlreadyExists otherwise. An adopted volume is
+// stamped with the ownership tags, so that the driver manages it from then on.
+func (d *TritonNFSDriver) findVolumeByName(ctx context.Context, volumes []*NFSVolume, name string, adopt bool) (*NFSVolume, error) {
+	for _, vol := range volumes {
+		if vol.Name != name {
+			continue
+		}
+		if d.ownsVolume(vol) {
+			return vol, nil
+		}
+		if !adopt {
+			return nil, status.Errorf(codes.AlreadyExists, "Volume with name %s (%s) already exists but is not owned by this driver, set %s to adopt it", name, vol.ID, paramAdoptExisting)
+		}
+		logrus.Infof("Adopting volume %s (%s) that is not owned by this driver", name, vol.ID)
+		adopted, err := d.volumeBackend(ctx).SetVolumeTags(ctx, vol.ID, d.ownershipTags())
+		if err != nil {
+			return nil, cloudAPIStatus(err, fmt.Sprintf("Failed to adopt volume %s", name))
+		}
+		return adopted, nil
+	}
+	return nil, nil
+}
+
+// parseAdoptExisting returns the value of the adoptExistingVolume parameter
+func parseAdoptExisting(params map[string]string) (bool, error) {
+	value, ok := params[paramAdoptExisting]
+	if !ok {
+		return false, nil
+	}
+	adopt, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "%s must be true or false, got %q", paramAdoptExisting, value)
+	}
+	return adopt, nil
+}
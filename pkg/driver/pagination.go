@@ -0,0 +1,68 @@
+package driver
+
+import (
+	"encoding/base64"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pageTokenPrefix guards page tokens against being mistaken for plain volume IDs
+const pageTokenPrefix = "start:"
+
+// encodePageToken returns the opaque token for the page that starts at the
+// volume with ID id
+func encodePageToken(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(pageTokenPrefix + id))
+}
+
+// decodePageToken returns the volume ID a page token starts at
+func decodePageToken(token string) (string, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", false
+	}
+	id, ok := strings.CutPrefix(string(raw), pageTokenPrefix)
+	if !ok || id == "" {
+		return "", false
+	}
+	return id, true
+}
+
+// paginateVolumes sorts volumes by ID and returns the page selected by
+// maxEntries and startingToken, and the token for the next page. Tokens hold
+// the ID the next page starts at rather than an offset, so volumes that are
+// created or deleted between calls do not shift later pages.
+func paginateVolumes(volumes []*NFSVolume, maxEntries int32, startingToken string) ([]*NFSVolume, string, error) {
+	if maxEntries < 0 {
+		return nil, "", status.Errorf(codes.InvalidArgument, "Max entries must not be negative, got %d", maxEntries)
+	}
+
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].ID < volumes[j].ID
+	})
+
+	start := 0
+	if startingToken != "" {
+		startID, ok := decodePageToken(startingToken)
+		if !ok {
+			return nil, "", status.Errorf(codes.Aborted, "Invalid starting token %q", startingToken)
+		}
+		start = sort.Search(len(volumes), func(i int) bool {
+			return volumes[i].ID >= startID
+		})
+	}
+
+	end := len(volumes)
+	if maxEntries > 0 && start+int(maxEntries) < end {
+		end = start + int(maxEntries)
+	}
+
+	var nextToken string
+	if end < len(volumes) {
+		nextToken = encodePageToken(volumes[end].ID)
+	}
+	return volumes[start:end], nextToken, nil
+}
@@ -0,0 +1,335 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Tags that mark a Triton volume as a snapshot
+const (
+	// tagSnapshotSource holds the ID of the volume the snapshot was taken from
+	tagSnapshotSource = "snapshot-source"
+
+	// tagSnapshotTime holds the time the snapshot was taken, in RFC 3339 format
+	tagSnapshotTime = "snapshot-time"
+)
+
+// snapshotCompleteMarker is written to the root of a snapshot volume once the
+// copy of the source volume has finished
+const snapshotCompleteMarker = ".tritonnfs-snapshot-complete"
+
+// isSnapshotVolume reports whether a Triton volume holds a snapshot
+func isSnapshotVolume(volume *NFSVolume) bool {
+	return volume.Tags[tagSnapshotSource] != ""
+}
+
+// CreateSnapshot creates a snapshot
+func (d *TritonNFSDriver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	// Validate arguments
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot name must be provided")
+	}
+	if req.GetSourceVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Source volume ID must be provided")
+	}
+
+	adopt, err := parseAdoptExisting(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the datacenter of the source volume and the Triton account of the
+	// request's secrets
+	ctx, sourceID, err := d.withVolumeDatacenter(ctx, req.GetSecrets(), req.GetSourceVolumeId())
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject concurrent creation of the same snapshot
+	release, err := d.lockOperation(lockPrefixVolumeName + req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Check if the snapshot already exists
+	volumes, err := d.volumeBackend(ctx).FindVolumes(ctx, &VolumeFilter{Name: req.GetName()})
+	if err != nil {
+		return nil, cloudAPIStatus(err, "Failed to list volumes")
+	}
+	vol, err := d.findVolumeByName(ctx, volumes, req.GetName(), adopt)
+	if err != nil {
+		return nil, err
+	}
+	if vol != nil {
+		if vol.Tags[tagSnapshotSource] != sourceID {
+			return nil, status.Errorf(codes.AlreadyExists, "Snapshot with name %s already exists but for a different source volume", req.GetName())
+		}
+		return d.createSnapshotResponse(ctx, vol)
+	}
+
+	// Get the source volume, or the parent of a subdirectory volume
+	source, _, err := d.backingVolume(ctx, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	if source.State != VolumeStateReady {
+		return nil, status.Errorf(codes.FailedPrecondition, "Source volume %s is in state %s", source.Name, source.State)
+	}
+
+	// Create the snapshot volume with the size and networks of the source
+	volumeRequest := &NFSVolumeRequest{
+		Name: req.GetName(),
+		Size: source.Size,
+		Type: TritonVolumeTypeNFS,
+		Tags: d.ownershipTags(),
+	}
+	volumeRequest.Tags[tagSnapshotSource] = sourceID
+	volumeRequest.Tags[tagSnapshotTime] = time.Now().UTC().Format(time.RFC3339)
+	if mountNetworkID := source.Tags[tagMountNetwork]; mountNetworkID != "" {
+		volumeRequest.Tags[tagMountNetwork] = mountNetworkID
+	}
+	for _, network := range source.Networks {
+		volumeRequest.Networks = append(volumeRequest.Networks, network.ID)
+	}
+
+	logrus.Infof("Creating snapshot %s of volume %s", req.GetName(), req.GetSourceVolumeId())
+	volume, err := d.volumeBackend(ctx).CreateVolume(ctx, volumeRequest)
+	if err != nil {
+		return nil, cloudAPIStatus(err, "Failed to create snapshot volume")
+	}
+
+	return d.createSnapshotResponse(ctx, volume)
+}
+
+// createSnapshotResponse returns the CreateSnapshot response for a snapshot
+// volume, starting the copy from the source volume once the snapshot volume is
+// ready. The snapshotter calls CreateSnapshot again until ReadyToUse is set.
+func (d *TritonNFSDriver) createSnapshotResponse(ctx context.Context, volume *NFSVolume) (*csi.CreateSnapshotResponse, error) {
+	if volume.State == VolumeStateFailed {
+		logrus.Warnf("Snapshot volume %s (%s) failed to provision, deleting it", volume.Name, volume.ID)
+		if err := d.volumeBackend(ctx).DeleteVolume(ctx, volume.ID); err != nil && !IsNotFound(err) {
+			return nil, cloudAPIStatus(err, fmt.Sprintf("Snapshot volume %s failed to provision and could not be deleted", volume.Name))
+		}
+		return nil, status.Errorf(codes.Internal, "Snapshot volume %s failed to provision", volume.Name)
+	}
+
+	ready, err := d.snapshotReady(ctx, volume, true)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to copy volume %s into snapshot %s: %v", volume.Tags[tagSnapshotSource], volume.Name, err)
+	}
+
+	return &csi.CreateSnapshotResponse{
+		Snapshot: d.snapshotFromVolume(ctx, volume, ready),
+	}, nil
+}
+
+// snapshotReady reports whether the copy into a snapshot volume has finished.
+// Snapshots that are not tagged complete and that this controller has not
+// copied are checked for the completion marker. When start is set, a copy is
+// started for snapshots that are not complete, and a failed copy is reported
+// once and then retried.
+func (d *TritonNFSDriver) snapshotReady(ctx context.Context, volume *NFSVolume, start bool) (bool, error) {
+	if volume.State != VolumeStateReady {
+		return false, nil
+	}
+	if volume.Tags[tagCopyComplete] != "" {
+		return true, nil
+	}
+
+	if start {
+		return d.copyProgress(ctx, volume, volume.Tags[tagSnapshotSource], snapshotCompleteMarker)
+	}
+	if c := d.volumeCopies.Get(volume.ID); c != nil {
+		done, err := c.Result()
+		return done && err == nil, err
+	}
+
+	// The copy may have been run by a previous controller
+	complete, err := d.hasCopyMarker(volume, snapshotCompleteMarker)
+	if err != nil {
+		return false, err
+	}
+	if complete {
+		d.volumeCopies.Complete(volume.ID)
+		d.markCopyComplete(ctx, volume)
+	}
+	return complete, nil
+}
+
+// snapshotFromVolume returns the CSI snapshot for a snapshot volume of the
+// datacenter serving ctx
+func (d *TritonNFSDriver) snapshotFromVolume(ctx context.Context, volume *NFSVolume, ready bool) *csi.Snapshot {
+	snapshot := &csi.Snapshot{
+		SnapshotId:     d.csiVolumeID(ctx, volume.ID),
+		SourceVolumeId: d.csiVolumeID(ctx, volume.Tags[tagSnapshotSource]),
+		SizeBytes:      volume.Size,
+		ReadyToUse:     ready,
+	}
+	if created, err := time.Parse(time.RFC3339, volume.Tags[tagSnapshotTime]); err == nil {
+		snapshot.CreationTime = timestamppb.New(created)
+	} else if !volume.Created.IsZero() {
+		snapshot.CreationTime = timestamppb.New(volume.Created)
+	}
+	return snapshot
+}
+
+// DeleteSnapshot deletes a snapshot
+func (d *TritonNFSDriver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	// Validate arguments
+	if req.GetSnapshotId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot ID must be provided")
+	}
+
+	// Use the datacenter of the snapshot and the Triton account of the
+	// request's secrets
+	ctx, snapshotID, err := d.withVolumeDatacenter(ctx, req.GetSecrets(), req.GetSnapshotId())
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject concurrent operations on the same snapshot
+	release, err := d.lockOperation(lockPrefixVolumeID + req.GetSnapshotId())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Stop a copy that is still running
+	if err := d.volumeCopies.Stop(ctx, snapshotID); err != nil {
+		return nil, status.Errorf(codes.Aborted, "Timed out stopping the copy into snapshot %s", req.GetSnapshotId())
+	}
+
+	// Make sure the volume is a snapshot before deleting it
+	volume, err := d.volumeBackend(ctx).GetVolume(ctx, snapshotID)
+	if err != nil {
+		if IsNotFound(err) {
+			if err := d.checkVolumeAccount(ctx, snapshotID, req.GetSnapshotId()); err != nil {
+				return nil, err
+			}
+			logrus.Warnf("Snapshot %s not found in account %s, assuming it's already deleted", req.GetSnapshotId(), d.requestAccount(ctx))
+			d.volumeCopies.Remove(snapshotID)
+			return &csi.DeleteSnapshotResponse{}, nil
+		}
+		return nil, cloudAPIStatus(err, "Failed to get snapshot")
+	}
+	if !isSnapshotVolume(volume) {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s is not a snapshot", req.GetSnapshotId())
+	}
+
+	err = d.volumeBackend(ctx).DeleteVolume(ctx, snapshotID)
+	if err != nil && !IsNotFound(err) {
+		if class, _ := classifyError(err); class == ErrorClassConflict {
+			return nil, status.Errorf(codes.FailedPrecondition, "Failed to delete snapshot: %v", err)
+		}
+		return nil, cloudAPIStatus(err, "Failed to delete snapshot")
+	}
+	d.volumeCopies.Remove(snapshotID)
+
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+// listedSnapshot is a snapshot volume with a context served by its datacenter
+type listedSnapshot struct {
+	ctx    context.Context
+	volume *NFSVolume
+}
+
+// ListSnapshots lists all snapshots
+func (d *TritonNFSDriver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	// Snapshots are paginated under their CSI IDs, and looked up by CSI ID
+	// in their datacenter to build the response
+	var snapshots []*NFSVolume
+	listed := make(map[string]listedSnapshot)
+	addSnapshots := func(ctx context.Context, volumes []*NFSVolume, sourceID string) {
+		for _, vol := range d.ownedVolumes(volumes) {
+			if !isSnapshotVolume(vol) {
+				continue
+			}
+			if sourceID != "" && vol.Tags[tagSnapshotSource] != sourceID {
+				continue
+			}
+			snapshot := d.withCSIVolumeID(ctx, vol)
+			snapshots = append(snapshots, snapshot)
+			listed[snapshot.ID] = listedSnapshot{ctx: ctx, volume: vol}
+		}
+	}
+
+	// Get the requested snapshot, the snapshots of the requested source
+	// volume, or the snapshots of every datacenter, with the Triton account
+	// of the request's secrets
+	switch {
+	case req.GetSnapshotId() != "":
+		ctx, snapshotID, err := d.withVolumeDatacenter(ctx, req.GetSecrets(), req.GetSnapshotId())
+		if err != nil {
+			return nil, err
+		}
+		volume, err := d.volumeBackend(ctx).GetVolume(ctx, snapshotID)
+		if err != nil && !IsNotFound(err) {
+			return nil, cloudAPIStatus(err, "Failed to get snapshot")
+		}
+		if err == nil {
+			addSnapshots(ctx, []*NFSVolume{volume}, "")
+		}
+	case req.GetSourceVolumeId() != "":
+		ctx, sourceID, err := d.withVolumeDatacenter(ctx, req.GetSecrets(), req.GetSourceVolumeId())
+		if err != nil {
+			return nil, err
+		}
+		volumes, err := d.volumeBackend(ctx).FindVolumes(ctx, &VolumeFilter{
+			Tags: map[string]string{tagSnapshotSource: sourceID},
+		})
+		if err != nil {
+			return nil, cloudAPIStatus(err, "Failed to list snapshots")
+		}
+		addSnapshots(ctx, volumes, sourceID)
+	default:
+		for _, datacenter := range d.datacenterNames() {
+			ctx, err := d.withDatacenter(ctx, req.GetSecrets(), datacenter)
+			if err != nil {
+				return nil, err
+			}
+			volumes, err := d.volumeBackend(ctx).ListVolumes(ctx)
+			if err != nil {
+				return nil, cloudAPIStatus(err, "Failed to list volumes")
+			}
+			addSnapshots(ctx, volumes, "")
+		}
+	}
+
+	page, nextToken, err := paginateVolumes(snapshots, req.GetMaxEntries(), req.GetStartingToken())
+	if err != nil {
+		return nil, err
+	}
+
+	// Build response. Pages of snapshots are ready once they are tagged or
+	// recorded complete, only a snapshot requested by ID is mounted to look
+	// for the completion marker of a copy that could not be tagged.
+	var entries []*csi.ListSnapshotsResponse_Entry
+	for _, vol := range page {
+		snapshot := listed[vol.ID]
+		ready := snapshot.volume.State == VolumeStateReady && d.copyComplete(snapshot.volume)
+		if !ready && req.GetSnapshotId() != "" {
+			var err error
+			ready, err = d.snapshotReady(snapshot.ctx, snapshot.volume, false)
+			if err != nil {
+				logrus.Warnf("Unable to determine if snapshot %s is complete: %v", vol.ID, err)
+			}
+		}
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{
+			Snapshot: d.snapshotFromVolume(snapshot.ctx, snapshot.volume, ready),
+		})
+	}
+
+	return &csi.ListSnapshotsResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
+}
@@ -0,0 +1,50 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestListSnapshotsReadyFromTag(t *testing.T) {
+	d, backend := newTestController(t)
+	ctx := context.Background()
+
+	source, err := d.CreateVolume(ctx, createVolumeRequest("pvc-1", 0))
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	sourceID := source.GetVolume().GetVolumeId()
+
+	// Snapshots copied by a previous controller, one of them tagged complete
+	snapshotTags := func(complete bool) map[string]string {
+		tags := d.ownershipTags()
+		tags[tagSnapshotSource] = sourceID
+		if complete {
+			tags[tagCopyComplete] = "2026-01-01T00:00:00Z"
+		}
+		return tags
+	}
+	complete, err := backend.CreateVolume(ctx, &NFSVolumeRequest{Name: "snapshot-1", Size: DefaultVolumeSizeBytes, Type: TritonVolumeTypeNFS, Tags: snapshotTags(true)})
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	incomplete, err := backend.CreateVolume(ctx, &NFSVolumeRequest{Name: "snapshot-2", Size: DefaultVolumeSizeBytes, Type: TritonVolumeTypeNFS, Tags: snapshotTags(false)})
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+
+	// Pages of snapshots are listed without mounting them
+	resp, err := d.ListSnapshots(ctx, &csi.ListSnapshotsRequest{SourceVolumeId: sourceID})
+	if err != nil {
+		t.Fatalf("ListSnapshots: %v", err)
+	}
+	ready := make(map[string]bool)
+	for _, entry := range resp.GetEntries() {
+		ready[entry.GetSnapshot().GetSnapshotId()] = entry.GetSnapshot().GetReadyToUse()
+	}
+	if len(ready) != 2 || !ready[complete.ID] || ready[incomplete.ID] {
+		t.Errorf("expected only snapshot %s to be ready, got %v", complete.ID, ready)
+	}
+}
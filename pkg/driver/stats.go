@@ -0,0 +1,89 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// volumeStatsTimeout bounds how long NodeGetVolumeStats waits for the NFS
+// server, so that a hung server does not block kubelet
+const volumeStatsTimeout = 10 * time.Second
+
+// errNotMountPoint is returned by statVolume when the path is not a mount point
+var errNotMountPoint = errors.New("path is not a mount point")
+
+// errStatsTimeout is returned by statVolume when the NFS server did not answer in time
+var errStatsTimeout = errors.New("timed out waiting for the NFS server")
+
+// isUnhealthyMountError reports whether err from statVolume means the NFS
+// mount is stale or the server stopped answering
+func isUnhealthyMountError(err error) bool {
+	return errors.Is(err, unix.ESTALE) || errors.Is(err, unix.EIO) || errors.Is(err, errStatsTimeout)
+}
+
+// volumeStats holds the filesystem usage of a mounted volume
+type volumeStats struct {
+	TotalBytes     int64
+	AvailableBytes int64
+	UsedBytes      int64
+	TotalInodes    int64
+	FreeInodes     int64
+	UsedInodes     int64
+}
+
+// statVolume checks that path is a mount point and returns its filesystem
+// usage. Both calls talk to the NFS server, so they run in a goroutine and
+// are abandoned after volumeStatsTimeout.
+func (d *TritonNFSDriver) statVolume(ctx context.Context, path string) (*volumeStats, error) {
+	type result struct {
+		stats *volumeStats
+		err   error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		notMount, err := d.mounter.IsLikelyNotMountPoint(path)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		if notMount {
+			done <- result{err: errNotMountPoint}
+			return
+		}
+
+		var st unix.Statfs_t
+		if err := unix.Statfs(path, &st); err != nil {
+			done <- result{err: &os.PathError{Op: "statfs", Path: path, Err: err}}
+			return
+		}
+
+		bsize := int64(st.Bsize)
+		stats := &volumeStats{
+			TotalBytes:     int64(st.Blocks) * bsize,
+			AvailableBytes: int64(st.Bavail) * bsize,
+			UsedBytes:      (int64(st.Blocks) - int64(st.Bfree)) * bsize,
+			TotalInodes:    int64(st.Files),
+			FreeInodes:     int64(st.Ffree),
+			UsedInodes:     int64(st.Files) - int64(st.Ffree),
+		}
+		done <- result{stats: stats}
+	}()
+
+	timer := time.NewTimer(volumeStatsTimeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		return res.stats, res.err
+	case <-timer.C:
+		return nil, fmt.Errorf("failed to stat %s: %w", path, errStatsTimeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
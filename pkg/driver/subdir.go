@@ -0,0 +1,386 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StorageClass parameters selecting subdirectory provisioning
+const (
+	// paramParentVolumeID provisions subdirectories of an existing Triton volume
+	paramParentVolumeID = "parentVolumeID"
+
+	// paramParentVolumeName provisions subdirectories of a Triton volume with
+	// this name, which the driver creates when it does not exist
+	paramParentVolumeName = "parentVolumeName"
+
+	// paramParentVolumeSize is the size of a driver-managed parent volume
+	paramParentVolumeSize = "parentVolumeSize"
+
+	// paramOnDelete selects what DeleteVolume does with a subdirectory:
+	// onDeleteDelete (the default) or onDeleteArchive
+	paramOnDelete = "onDelete"
+)
+
+// Values of the onDelete parameter
+const (
+	onDeleteDelete  = "delete"
+	onDeleteArchive = "archive"
+)
+
+// Volume context key carrying the subdirectory of the share to mount
+const volumeContextSubDir = "subDir"
+
+// archivedSubDirPrefix prefixes subdirectories that were archived on delete
+const archivedSubDirPrefix = "archived-"
+
+// subDirMode is the mode of new subdirectories. Pods may run as any user, so
+// every user can write to their claim, as on a fresh Triton volume.
+const subDirMode os.FileMode = 0777
+
+// Tag that marks a Triton volume as a driver-managed parent volume
+const (
+	// tagRole holds the role of a volume the driver created for itself
+	tagRole = "role"
+
+	// roleSubDirParent is the role of driver-managed parent volumes
+	roleSubDirParent = "subdirectory-parent"
+)
+
+// isSubDirParentVolume reports whether a Triton volume is a driver-managed
+// parent of subdirectory volumes
+func isSubDirParentVolume(volume *NFSVolume) bool {
+	return volume.Tags[tagRole] == roleSubDirParent
+}
+
+// subDirVolumeID identifies a volume that is a subdirectory of a Triton
+// volume. It is formatted as "<parent ID>/<subdirectory>", followed by
+// "?onDelete=archive" when the subdirectory is archived on delete.
+type subDirVolumeID struct {
+	ParentID string
+	SubDir   string
+	OnDelete string
+}
+
+// String formats the volume ID
+func (v *subDirVolumeID) String() string {
+	id := v.ParentID + "/" + v.SubDir
+	if v.OnDelete != "" && v.OnDelete != onDeleteDelete {
+		id += "?" + url.Values{paramOnDelete: []string{v.OnDelete}}.Encode()
+	}
+	return id
+}
+
+// parseSubDirVolumeID parses a subdirectory volume ID. It reports false for
+// the IDs of whole Triton volumes.
+func parseSubDirVolumeID(id string) (*subDirVolumeID, bool, error) {
+	parentID, rest, ok := strings.Cut(id, "/")
+	if !ok {
+		return nil, false, nil
+	}
+
+	subDir, query, _ := strings.Cut(rest, "?")
+	if parentID == "" || !isValidSubDir(subDir) {
+		return nil, true, fmt.Errorf("invalid subdirectory volume ID %q", id)
+	}
+
+	volumeID := &subDirVolumeID{ParentID: parentID, SubDir: subDir, OnDelete: onDeleteDelete}
+	if query != "" {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid subdirectory volume ID %q: %v", id, err)
+		}
+		if onDelete := values.Get(paramOnDelete); onDelete != "" {
+			volumeID.OnDelete = onDelete
+		}
+	}
+	if volumeID.OnDelete != onDeleteDelete && volumeID.OnDelete != onDeleteArchive {
+		return nil, true, fmt.Errorf("invalid subdirectory volume ID %q: unknown onDelete %q", id, volumeID.OnDelete)
+	}
+	return volumeID, true, nil
+}
+
+// isValidSubDir reports whether name can be used as a single path element
+func isValidSubDir(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/?\x00")
+}
+
+// subDirName returns the subdirectory name for a volume name
+func subDirName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '?' || r == 0 {
+			return '-'
+		}
+		return r
+	}, name)
+	if name == "." || name == ".." {
+		name = strings.Repeat("-", len(name))
+	}
+	return name
+}
+
+// isSubDirRequest reports whether CreateVolume parameters select subdirectory provisioning
+func isSubDirRequest(params map[string]string) bool {
+	return params[paramParentVolumeID] != "" || params[paramParentVolumeName] != ""
+}
+
+// backingVolume returns the Triton volume behind a volume ID: the volume
+// itself, or the parent volume of a subdirectory volume. subDirID is nil for
+// whole volumes.
+func (d *TritonNFSDriver) backingVolume(ctx context.Context, id string) (volume *NFSVolume, subDirID *subDirVolumeID, err error) {
+	subDirID, isSubDir, err := parseSubDirVolumeID(id)
+	if err != nil {
+		return nil, nil, status.Error(codes.NotFound, err.Error())
+	}
+	if isSubDir {
+		id = subDirID.ParentID
+	}
+
+	volume, err = d.volumeBackend(ctx).GetVolume(ctx, id)
+	if err != nil {
+		return nil, nil, cloudAPIStatus(err, fmt.Sprintf("Failed to get volume %s", id))
+	}
+	return volume, subDirID, nil
+}
+
+// createSubDirVolume provisions a volume as a subdirectory of a parent Triton
+// volume. The parent is mounted temporarily on the controller to create the
+// subdirectory.
+func (d *TritonNFSDriver) createSubDirVolume(ctx context.Context, req *csi.CreateVolumeRequest, mountOptions []string) (*csi.CreateVolumeResponse, error) {
+	params := req.GetParameters()
+	if params[paramParentVolumeID] != "" && params[paramParentVolumeName] != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "Only one of %s and %s may be set", paramParentVolumeID, paramParentVolumeName)
+	}
+
+	onDelete := params[paramOnDelete]
+	if onDelete == "" {
+		onDelete = onDeleteDelete
+	}
+	if onDelete != onDeleteDelete && onDelete != onDeleteArchive {
+		return nil, status.Errorf(codes.InvalidArgument, "%s must be %s or %s, got %q", paramOnDelete, onDeleteDelete, onDeleteArchive, onDelete)
+	}
+
+	// Get the parent volume, creating a driver-managed parent if needed
+	var parent *NFSVolume
+	var err error
+	if parentID := params[paramParentVolumeID]; parentID != "" {
+		// A parent ID without a datacenter is in the datacenter of the request
+		if datacenter, _ := d.splitVolumeID(parentID); datacenter != "" {
+			parentID, err = d.localVolumeID(ctx, parentID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		parent, err = d.volumeBackend(ctx).GetVolume(ctx, parentID)
+		if err != nil {
+			return nil, cloudAPIStatus(err, fmt.Sprintf("Failed to get parent volume %s", parentID))
+		}
+	} else {
+		parent, err = d.ensureParentVolume(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if parent.State != VolumeStateReady {
+		return nil, status.Errorf(codes.Aborted, "Parent volume %s is in state %s, waiting for it to become ready", parent.Name, parent.State)
+	}
+	if err := checkMountNetwork(parent); err != nil {
+		return nil, err
+	}
+
+	// Create the subdirectory
+	volumeID := &subDirVolumeID{
+		ParentID: parent.ID,
+		SubDir:   subDirName(req.GetName()),
+		OnDelete: onDelete,
+	}
+	err = d.withVolumeMounted(parent, mountOptions, func(dir string) error {
+		subDir := filepath.Join(dir, volumeID.SubDir)
+		if err := os.MkdirAll(subDir, subDirMode); err != nil {
+			return err
+		}
+		// MkdirAll is subject to the umask
+		return os.Chmod(subDir, subDirMode)
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to create subdirectory %s on volume %s: %v", volumeID.SubDir, parent.Name, err)
+	}
+	logrus.Infof("Created subdirectory %s on parent volume %s (%s)", volumeID.SubDir, parent.Name, parent.ID)
+
+	// Subdirectories have no quota, so report the requested capacity
+	capacity := req.GetCapacityRange().GetRequiredBytes()
+	if capacity == 0 {
+		capacity = req.GetCapacityRange().GetLimitBytes()
+	}
+
+	volumeContext := nfsVolumeContext(parent)
+	volumeContext[volumeContextSubDir] = volumeID.SubDir
+	if len(mountOptions) > 0 {
+		volumeContext[volumeContextMountOptions] = strings.Join(mountOptions, ",")
+	}
+
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:           d.csiVolumeID(ctx, volumeID.String()),
+			CapacityBytes:      capacity,
+			VolumeContext:      volumeContext,
+			AccessibleTopology: d.accessibleTopology(ctx, parent, req.GetAccessibilityRequirements()),
+		},
+	}, nil
+}
+
+// ensureParentVolume returns the driver-managed parent volume named by the
+// parentVolumeName parameter, creating it if it does not exist yet
+func (d *TritonNFSDriver) ensureParentVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*NFSVolume, error) {
+	params := req.GetParameters()
+	name := params[paramParentVolumeName]
+
+	adopt, err := parseAdoptExisting(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject concurrent creation of the same parent volume
+	release, err := d.lockOperation(lockPrefixVolumeName + name)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	volumes, err := d.volumeBackend(ctx).FindVolumes(ctx, &VolumeFilter{Name: name})
+	if err != nil {
+		return nil, cloudAPIStatus(err, "Failed to list volumes")
+	}
+	vol, err := d.findVolumeByName(ctx, volumes, name, adopt)
+	if err != nil {
+		return nil, err
+	}
+	if vol != nil && !isSubDirParentVolume(vol) {
+		// An adopted parent volume is hidden from ListVolumes like the
+		// parent volumes the driver creates
+		vol, err = d.volumeBackend(ctx).SetVolumeTags(ctx, vol.ID, map[string]string{tagRole: roleSubDirParent})
+		if err != nil {
+			return nil, cloudAPIStatus(err, fmt.Sprintf("Failed to adopt parent volume %s", name))
+		}
+	}
+	if vol != nil {
+		return vol, nil
+	}
+
+	// Size the parent from the parentVolumeSize parameter, or use the default size
+	capacityRange := &csi.CapacityRange{}
+	if sizeStr := params[paramParentVolumeSize]; sizeStr != "" {
+		size, err := parseSize(sizeStr)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid %s: %v", paramParentVolumeSize, err)
+		}
+		capacityRange.RequiredBytes = size
+	}
+	size, err := d.volumeSizeForRange(ctx, capacityRange)
+	if err != nil {
+		return nil, err
+	}
+
+	networks, mountNetworkID, err := d.volumeNetworks(ctx, params, req.GetAccessibilityRequirements())
+	if err != nil {
+		return nil, err
+	}
+
+	volumeRequest := &NFSVolumeRequest{
+		Name:     name,
+		Size:     size,
+		Type:     TritonVolumeTypeNFS,
+		Networks: networks,
+		Tags:     d.ownershipTags(),
+	}
+	volumeRequest.Tags[tagRole] = roleSubDirParent
+	if mountNetworkID != "" {
+		volumeRequest.Tags[tagMountNetwork] = mountNetworkID
+	}
+
+	logrus.Infof("Creating parent volume %s for subdirectory volumes", name)
+	volume, err := d.volumeBackend(ctx).CreateVolume(ctx, volumeRequest)
+	if err != nil {
+		return nil, cloudAPIStatus(err, "Failed to create parent volume")
+	}
+	return volume, nil
+}
+
+// deleteSubDirVolume removes or archives the subdirectory of a volume. A
+// missing parent volume or subdirectory is not an error.
+func (d *TritonNFSDriver) deleteSubDirVolume(ctx context.Context, volumeID *subDirVolumeID) error {
+	parent, err := d.volumeBackend(ctx).GetVolume(ctx, volumeID.ParentID)
+	if err != nil {
+		if IsNotFound(err) {
+			if err := d.checkVolumeAccount(ctx, volumeID.ParentID, volumeID.ParentID); err != nil {
+				return err
+			}
+			logrus.Warnf("Parent volume %s not found in account %s, assuming subdirectory %s is already deleted", volumeID.ParentID, d.requestAccount(ctx), volumeID.SubDir)
+			return nil
+		}
+		return cloudAPIStatus(err, fmt.Sprintf("Failed to get parent volume %s", volumeID.ParentID))
+	}
+	if parent.State != VolumeStateReady {
+		return status.Errorf(codes.Unavailable, "Parent volume %s is in state %s", parent.Name, parent.State)
+	}
+
+	err = d.withVolumeMounted(parent, nil, func(dir string) error {
+		subDir := filepath.Join(dir, volumeID.SubDir)
+		if _, err := os.Stat(subDir); os.IsNotExist(err) {
+			return nil
+		}
+
+		if volumeID.OnDelete == onDeleteArchive {
+			archived := fmt.Sprintf("%s%s-%d", archivedSubDirPrefix, volumeID.SubDir, time.Now().Unix())
+			logrus.Infof("Archiving subdirectory %s of volume %s as %s", volumeID.SubDir, parent.Name, archived)
+			return os.Rename(subDir, filepath.Join(dir, archived))
+		}
+
+		logrus.Infof("Deleting subdirectory %s of volume %s", volumeID.SubDir, parent.Name)
+		return os.RemoveAll(subDir)
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to delete subdirectory %s of volume %s: %v", volumeID.SubDir, parent.Name, err)
+	}
+	return nil
+}
+
+// parseSize parses a size in bytes, or with a Ki, Mi, Gi or Ti suffix
+func parseSize(size string) (int64, error) {
+	multipliers := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"Ki", 1 << 10},
+		{"Mi", 1 << 20},
+		{"Gi", 1 << 30},
+		{"Ti", 1 << 40},
+	}
+
+	multiplier := int64(1)
+	number := size
+	for _, m := range multipliers {
+		if strings.HasSuffix(size, m.suffix) {
+			multiplier = m.multiplier
+			number = strings.TrimSuffix(size, m.suffix)
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,84 @@
+package driver
+
+import (
+	"context"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// nodeTopology returns the topology segments the node reports, or nil when
+// neither a zone nor a node network is configured
+func (d *TritonNFSDriver) nodeTopology() *csi.Topology {
+	segments := make(map[string]string)
+	if d.zone != "" {
+		segments[TopologyKeyZone] = d.zone
+	}
+	if d.nodeNetwork != "" {
+		segments[TopologyKeyNetwork] = d.nodeNetwork
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+	return &csi.Topology{Segments: segments}
+}
+
+// topologyNetwork returns the ID of the network to mount a new volume through
+// for the accessibility requirements of a CreateVolume request. That is the
+// network of the first preferred, then requisite, topology in the datacenter
+// serving ctx. A mount network set by the StorageClass only matches
+// topologies on that network. Without requirements, or when the chosen
+// topology has no network segment, mountNetworkID is returned unchanged.
+func (d *TritonNFSDriver) topologyNetwork(ctx context.Context, requirements *csi.TopologyRequirement, mountNetworkID string) (string, error) {
+	datacenter := d.requestDatacenter(ctx)
+	topologies := append(append([]*csi.Topology{}, requirements.GetPreferred()...), requirements.GetRequisite()...)
+	if len(topologies) == 0 {
+		return mountNetworkID, nil
+	}
+
+	for _, topology := range topologies {
+		segments := topology.GetSegments()
+		if zone, ok := segments[TopologyKeyZone]; ok && datacenter != "" && zone != datacenter {
+			continue
+		}
+		networkID, ok := segments[TopologyKeyNetwork]
+		if !ok {
+			return mountNetworkID, nil
+		}
+		if mountNetworkID != "" && networkID != mountNetworkID {
+			continue
+		}
+		return networkID, nil
+	}
+
+	if mountNetworkID != "" {
+		return "", status.Errorf(codes.ResourceExhausted, "No requested topology is in zone %q on mount network %s", datacenter, mountNetworkID)
+	}
+	return "", status.Errorf(codes.ResourceExhausted, "No requested topology is in zone %q", datacenter)
+}
+
+// accessibleTopology returns the topology a volume is accessible from, using
+// the topology keys the nodes report in requirements: the datacenter serving
+// ctx, and the network the volume is mounted through. It returns nil when the
+// nodes report no topology, so volumes stay schedulable everywhere.
+func (d *TritonNFSDriver) accessibleTopology(ctx context.Context, volume *NFSVolume, requirements *csi.TopologyRequirement) []*csi.Topology {
+	keys := make(map[string]bool)
+	for _, topology := range append(append([]*csi.Topology{}, requirements.GetRequisite()...), requirements.GetPreferred()...) {
+		for key := range topology.GetSegments() {
+			keys[key] = true
+		}
+	}
+
+	segments := make(map[string]string)
+	if datacenter := d.requestDatacenter(ctx); keys[TopologyKeyZone] && datacenter != "" {
+		segments[TopologyKeyZone] = datacenter
+	}
+	if networkID := volume.Tags[tagMountNetwork]; keys[TopologyKeyNetwork] && networkID != "" {
+		segments[TopologyKeyNetwork] = networkID
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+	return []*csi.Topology{{Segments: segments}}
+}
@@ -2,16 +2,26 @@ package driver
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"encoding/pem"
 
 	triton "github.com/joyent/triton-go/v2"
 	"github.com/joyent/triton-go/v2/authentication"
+	"github.com/joyent/triton-go/v2/client"
 	"github.com/joyent/triton-go/v2/compute"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // TritonClient is a client for the Triton CloudAPI
@@ -21,8 +31,31 @@ type TritonClient struct {
 	accountID     string
 	keyID         string
 	keyPath       string
+
+	sizesMu      sync.Mutex
+	sizes        []int64
+	sizesFetched time.Time
+
+	// listMu serializes ListVolumes so that concurrent callers share one
+	// CloudAPI call. listGeneration is bumped by every change to a volume,
+	// and a list fetched under an older generation is not used.
+	listMu         sync.Mutex
+	listed         []*NFSVolume
+	listFetched    time.Time
+	listFetchedGen uint64
+	listGeneration atomic.Uint64
+
+	networksMu      sync.Mutex
+	networks        []*Network
+	networksFetched time.Time
 }
 
+// networksCacheTTL is how long the list of networks is cached
+const networksCacheTTL = 5 * time.Minute
+
+// volumeListCacheTTL is how long the list of all volumes is cached
+const volumeListCacheTTL = 5 * time.Second
+
 // NewTritonClient creates a new TritonClient with the given options
 func NewTritonClient(endpoint, accountID, keyID, keyPath string) (*TritonClient, error) {
 	logrus.Infof("Creating Triton client with endpoint: %s, accountID: %s, keyID: %s, keyPath: %s", endpoint, accountID, keyID, keyPath)
@@ -37,7 +70,18 @@ func NewTritonClient(endpoint, accountID, keyID, keyPath string) (*TritonClient,
 	if len(privateKeyData) > 50 {
 		logrus.Infof("Private key starts with: %s", string(privateKeyData[:50]))
 	}
-	
+
+	client, err := NewTritonClientWithKey(context.Background(), endpoint, accountID, keyID, privateKeyData)
+	if err != nil {
+		return nil, err
+	}
+	client.keyPath = keyPath
+	return client, nil
+}
+
+// NewTritonClientWithKey creates a new TritonClient that signs requests with
+// the given PEM private key. ctx bounds the call that verifies the connection.
+func NewTritonClientWithKey(ctx context.Context, endpoint, accountID, keyID string, privateKeyData []byte) (*TritonClient, error) {
 	// Parse the private key
 	block, _ := pem.Decode(privateKeyData)
 	if block == nil {
@@ -80,10 +124,15 @@ func NewTritonClient(endpoint, accountID, keyID, keyPath string) (*TritonClient,
 	
 	// Verify connection with a simple API call
 	logrus.Infof("Testing connection to Triton API")
-	volumes, err := computeClient.Volumes().List(context.Background(), &compute.ListVolumesInput{})
+	var volumes []*compute.Volume
+	err = callCloudAPI(ctx, "ListVolumes", true, func(ctx context.Context) error {
+		var err error
+		volumes, err = computeClient.Volumes().List(ctx, &compute.ListVolumesInput{})
+		return err
+	})
 	if err != nil {
 		logrus.Errorf("Failed to list volumes using triton-go client: %v", err)
-		return nil, fmt.Errorf("failed to connect to Triton API: %v", err)
+		return nil, fmt.Errorf("failed to connect to Triton API: %w", err)
 	}
 	
 	logrus.Infof("Successfully connected to Triton API, found %d volumes", len(volumes))
@@ -93,7 +142,6 @@ func NewTritonClient(endpoint, accountID, keyID, keyPath string) (*TritonClient,
 		endpoint:      endpoint,
 		accountID:     accountID,
 		keyID:         keyID,
-		keyPath:       keyPath,
 	}, nil
 }
 
@@ -123,16 +171,19 @@ type NFSVolume struct {
 	Server         string            `json:"server"`         // Server IP for the NFS volume
 }
 
-// Network represents a network in Triton
+// Network represents a network in Triton. IP is the NFS server address of a
+// volume on the network, when known.
 type Network struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
-	IP   string `json:"ip"`
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Subnet string `json:"subnet"`
+	IP     string `json:"ip"`
 }
 
 // CreateVolume creates a new NFS volume
 func (c *TritonClient) CreateVolume(ctx context.Context, req *NFSVolumeRequest) (*NFSVolume, error) {
 	logrus.Infof("Creating volume with name: %s, size: %d", req.Name, req.Size)
+	defer c.invalidateVolumeList()
 	
 	// Use the triton-go compute client
 	if c.computeClient == nil {
@@ -159,53 +210,26 @@ func (c *TritonClient) CreateVolume(ctx context.Context, req *NFSVolumeRequest)
 	}
 	
 	// Create the volume
-	volume, err := c.computeClient.Volumes().Create(ctx, createInput)
+	var volume *compute.Volume
+	err := callCloudAPI(ctx, "CreateVolume", false, func(ctx context.Context) error {
+		var err error
+		volume, err = c.computeClient.Volumes().Create(ctx, createInput)
+		return err
+	})
 	if err != nil {
 		logrus.Errorf("Failed to create volume using triton-go client: %v", err)
 		return nil, err
 	}
 	
-	// Wait for the volume to be ready
-	volumeID := volume.ID
+	// Triton provisions the volume asynchronously, callers poll GetVolume
+	// until it is ready
+	logrus.Infof("Volume %s is being provisioned in state %s", volume.ID, volume.State)
 	
-	// Poll for volume state until it's ready or fails
-	readyVolume, err := c.waitForVolumeReady(ctx, volumeID)
-	if err != nil {
-		logrus.Errorf("Volume creation initiated but failed to reach ready state: %v", err)
+	nfsVolume := nfsVolumeFromTriton(volume)
+	if err := c.describeNetworks(ctx, nfsVolume); err != nil {
 		return nil, err
 	}
 	
-	// Check if FileSystemPath exists
-	if readyVolume.FileSystemPath == "" {
-		logrus.Warnf("Volume %s is ready but has no FileSystemPath", volumeID)
-	} else {
-		logrus.Infof("Volume %s created with FileSystemPath: %s", volumeID, readyVolume.FileSystemPath)
-	}
-	
-	// Convert to our internal NFSVolume type
-	nfsVolume := &NFSVolume{
-		ID:             readyVolume.ID,
-		Name:           readyVolume.Name,
-		State:          readyVolume.State,
-		Type:           readyVolume.Type,
-		Size:           int64(readyVolume.Size) * 1024 * 1024, // Convert MB to bytes
-		MountPoint:     readyVolume.FileSystemPath,
-		FileSystemPath: readyVolume.FileSystemPath, // This contains the full NFS path including IP
-		Created:        time.Now(), // No Created field in compute.Volume
-		Tags:           readyVolume.Tags,
-		Networks:       []Network{}, // Initialize with empty slice
-	}
-	
-	// Add networks from the volume
-	// In the triton-go library, Networks might be a []string of network IDs
-	for _, netID := range readyVolume.Networks {
-		nfsVolume.Networks = append(nfsVolume.Networks, Network{
-			ID:   netID,
-			Name: "network",
-			IP:   "",
-		})
-	}
-	
 	return nfsVolume, nil
 }
 
@@ -227,9 +251,7 @@ func (c *TritonClient) GetVolume(ctx context.Context, id string) (*NFSVolume, er
 	}
 	
 	// Get the volume from Triton
-	volume, err := c.computeClient.Volumes().Get(ctx, &compute.GetVolumeInput{
-		ID: id,
-	})
+	volume, err := c.getVolume(ctx, id)
 	
 	if err != nil {
 		logrus.Errorf("Failed to get volume using triton-go client: %v", err)
@@ -247,28 +269,9 @@ func (c *TritonClient) GetVolume(ctx context.Context, id string) (*NFSVolume, er
 		logrus.Infof("Volume %s has FileSystemPath: %s", id, volume.FileSystemPath)
 	}
 	
-	// Convert to our internal NFSVolume type
-	nfsVolume := &NFSVolume{
-		ID:             volume.ID,
-		Name:           volume.Name,
-		State:          volume.State,
-		Type:           volume.Type,
-		Size:           int64(volume.Size) * 1024 * 1024, // Convert MB to bytes
-		MountPoint:     volume.FileSystemPath,
-		FileSystemPath: volume.FileSystemPath, // This contains the full NFS path including IP
-		Created:        time.Now(), // No Created field in compute.Volume
-		Tags:           volume.Tags,
-		Networks:       []Network{}, // Initialize with empty slice
-	}
-	
-	// Add networks from the volume
-	// In the triton-go library, Networks is a []string of network IDs
-	for _, netID := range volume.Networks {
-		nfsVolume.Networks = append(nfsVolume.Networks, Network{
-			ID:   netID,
-			Name: "network",
-			IP:   "",
-		})
+	nfsVolume := nfsVolumeFromTriton(volume)
+	if err := c.describeNetworks(ctx, nfsVolume); err != nil {
+		return nil, err
 	}
 	
 	return nfsVolume, nil
@@ -277,6 +280,7 @@ func (c *TritonClient) GetVolume(ctx context.Context, id string) (*NFSVolume, er
 // DeleteVolume deletes a volume by ID
 func (c *TritonClient) DeleteVolume(ctx context.Context, id string) error {
 	logrus.Infof("Deleting volume with ID: %s", id)
+	defer c.invalidateVolumeList()
 	
 	// Use the triton-go compute client
 	if c.computeClient == nil {
@@ -292,8 +296,10 @@ func (c *TritonClient) DeleteVolume(ctx context.Context, id string) error {
 	}
 	
 	// Delete the volume using the Triton API
-	err := c.computeClient.Volumes().Delete(ctx, &compute.DeleteVolumeInput{
-		ID: id,
+	err := callCloudAPI(ctx, "DeleteVolume", true, func(ctx context.Context) error {
+		return c.computeClient.Volumes().Delete(ctx, &compute.DeleteVolumeInput{
+			ID: id,
+		})
 	})
 	
 	if err != nil {
@@ -306,9 +312,12 @@ func (c *TritonClient) DeleteVolume(ctx context.Context, id string) error {
 	return nil
 }
 
-// ExpandVolume expands an existing volume to a new size
+// ExpandVolume starts expanding an existing volume to a new size. Triton
+// resizes the volume asynchronously, so the returned volume may still be in
+// the resizing state.
 func (c *TritonClient) ExpandVolume(ctx context.Context, id string, newSize int64) (*NFSVolume, error) {
 	logrus.Infof("Expanding volume with ID: %s to new size: %d bytes", id, newSize)
+	defer c.invalidateVolumeList()
 	
 	// Use the triton-go compute client
 	if c.computeClient == nil {
@@ -324,9 +333,7 @@ func (c *TritonClient) ExpandVolume(ctx context.Context, id string, newSize int6
 	}
 	
 	// First get the current volume
-	currentVolume, err := c.computeClient.Volumes().Get(ctx, &compute.GetVolumeInput{
-		ID: id,
-	})
+	currentVolume, err := c.getVolume(ctx, id)
 	
 	if err != nil {
 		logrus.Errorf("Failed to get volume using triton-go client: %v", err)
@@ -340,145 +347,330 @@ func (c *TritonClient) ExpandVolume(ctx context.Context, id string, newSize int6
 			id, currentSizeBytes)
 			
 		// Return the current volume since it's already large enough
-		nfsVolume := &NFSVolume{
-			ID:             currentVolume.ID,
-			Name:           currentVolume.Name,
-			State:          currentVolume.State,
-			Type:           currentVolume.Type,
-			Size:           currentSizeBytes,
-			MountPoint:     currentVolume.FileSystemPath,
-			FileSystemPath: currentVolume.FileSystemPath, // Use the full filesystem path from Triton API
-			Created:        time.Now(), // No Created field in compute.Volume
-			Tags:           currentVolume.Tags,
-			Networks:       []Network{}, // Initialize with empty slice
-		}
-		
-		// Add networks from the volume
-		for _, netID := range currentVolume.Networks {
-			nfsVolume.Networks = append(nfsVolume.Networks, Network{
-				ID:   netID,
-				Name: "network",
-				IP:   "",
-			})
+		nfsVolume := nfsVolumeFromTriton(currentVolume)
+		if err := c.describeNetworks(ctx, nfsVolume); err != nil {
+			return nil, err
 		}
 		
 		return nfsVolume, nil
 	}
 	
-	// Volume expansion is not implemented yet
-	logrus.Warnf("Volume expansion not implemented yet. Cannot expand volume %s to size %d bytes", id, newSize)
-	return nil, fmt.Errorf("volume expansion not implemented yet")
+	// Triton only accepts sizes from its volume size catalogue, so round up
+	// to the smallest offered size that fits
+	sizes, err := c.ListVolumeSizes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	offeredSize, err := selectVolumeSize(sizes, newSize, 0)
+	if err != nil {
+		return nil, err
+	}
+	newSizeMB := offeredSize / (1024 * 1024)
+
+	logrus.Infof("Resizing volume %s from %d MB to %d MB", id, currentVolume.Size, newSizeMB)
+	err = callCloudAPI(ctx, "UpdateVolume", true, func(ctx context.Context) error {
+		resp, err := c.computeClient.Client.ExecuteRequest(ctx, client.RequestInput{
+			Method: http.MethodPost,
+			Path:   path.Join("/", c.accountID, "volumes", id),
+			Body:   map[string]interface{}{"size": newSizeMB},
+		})
+		if resp != nil {
+			resp.Close()
+		}
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("Failed to resize volume using triton-go client: %v", err)
+		return nil, err
+	}
+
+	resizingVolume, err := c.getVolume(ctx, id)
+	if err != nil {
+		logrus.Errorf("Volume resize initiated but the volume could not be fetched: %v", err)
+		return nil, err
+	}
+
+	nfsVolume := nfsVolumeFromTriton(resizingVolume)
+	if err := c.describeNetworks(ctx, nfsVolume); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Volume %s is %s with %d bytes", id, nfsVolume.State, nfsVolume.Size)
+	return nfsVolume, nil
+}
+
+// SetVolumeTags sets tags on a volume, keeping the other tags it carries.
+// CloudAPI replaces the whole tag set of a volume, so the current tags are
+// read first.
+func (c *TritonClient) SetVolumeTags(ctx context.Context, id string, tags map[string]string) (*NFSVolume, error) {
+	defer c.invalidateVolumeList()
+
+	volume, err := c.getVolume(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]string, len(volume.Tags)+len(tags))
+	for k, v := range volume.Tags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+
+	logrus.Infof("Setting tags %v on volume %s", tags, id)
+	err = callCloudAPI(ctx, "UpdateVolumeTags", true, func(ctx context.Context) error {
+		resp, err := c.computeClient.Client.ExecuteRequest(ctx, client.RequestInput{
+			Method: http.MethodPut,
+			Path:   path.Join("/", c.accountID, "volumes", id),
+			Body:   map[string]interface{}{"tags": merged},
+		})
+		if resp != nil {
+			resp.Close()
+		}
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("Failed to set the tags of volume %s: %v", id, err)
+		return nil, err
+	}
+
+	volume, err = c.getVolume(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	nfsVolume := nfsVolumeFromTriton(volume)
+	if err := c.describeNetworks(ctx, nfsVolume); err != nil {
+		return nil, err
+	}
+	return nfsVolume, nil
 }
 
-// ListVolumes lists all volumes
+// volumeSize is an entry of the CloudAPI volume size catalogue
+type volumeSize struct {
+	Size        int64  `json:"size"` // in MB
+	Description string `json:"description"`
+}
+
+// ListVolumeSizes returns the NFS volume sizes offered by Triton in bytes,
+// smallest first. The catalogue rarely changes, so it is cached for volumeSizesCacheTTL.
+func (c *TritonClient) ListVolumeSizes(ctx context.Context) ([]int64, error) {
+	c.sizesMu.Lock()
+	defer c.sizesMu.Unlock()
+
+	if c.sizes != nil && time.Since(c.sizesFetched) < volumeSizesCacheTTL {
+		return c.sizes, nil
+	}
+
+	var entries []volumeSize
+	err := callCloudAPI(ctx, "ListVolumeSizes", true, func(ctx context.Context) error {
+		resp, err := c.computeClient.Client.ExecuteRequest(ctx, client.RequestInput{
+			Method: http.MethodGet,
+			Path:   path.Join("/", c.accountID, "volumesizes"),
+			Query:  &url.Values{"type": []string{TritonVolumeTypeNFS}},
+		})
+		if resp != nil {
+			defer resp.Close()
+		}
+		if err != nil {
+			return err
+		}
+		if err := json.NewDecoder(resp).Decode(&entries); err != nil {
+			return fmt.Errorf("failed to decode volume sizes: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("Failed to list volume sizes: %v", err)
+		return nil, err
+	}
+
+	sizes := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		sizes = append(sizes, entry.Size*1024*1024) // Convert MB to bytes
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+
+	logrus.Infof("Triton offers %d volume sizes", len(sizes))
+	c.sizes = sizes
+	c.sizesFetched = time.Now()
+
+	return sizes, nil
+}
+
+// ListVolumes lists all volumes. The health monitor and the metrics call it
+// often, so the list is cached for volumeListCacheTTL; creating, deleting or
+// resizing a volume drops the cache.
 func (c *TritonClient) ListVolumes(ctx context.Context) ([]*NFSVolume, error) {
-	logrus.Infof("Listing volumes")
-	
-	// Use the triton-go compute client
+	c.listMu.Lock()
+	defer c.listMu.Unlock()
+
+	generation := c.listGeneration.Load()
+	if !c.listFetched.IsZero() && c.listFetchedGen == generation && time.Since(c.listFetched) < volumeListCacheTTL {
+		return append([]*NFSVolume(nil), c.listed...), nil
+	}
+
+	volumes, err := c.FindVolumes(ctx, &VolumeFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	c.listed = volumes
+	c.listFetched = time.Now()
+	c.listFetchedGen = generation
+	return append([]*NFSVolume(nil), volumes...), nil
+}
+
+// invalidateVolumeList drops the cached list of all volumes
+func (c *TritonClient) invalidateVolumeList() {
+	c.listGeneration.Add(1)
+}
+
+// FindVolumes lists the volumes selected by filter. The name and state are
+// evaluated by CloudAPI, which cannot filter by tags, so tags are matched on
+// the volumes it returns.
+func (c *TritonClient) FindVolumes(ctx context.Context, filter *VolumeFilter) ([]*NFSVolume, error) {
 	if c.computeClient == nil {
 		return nil, fmt.Errorf("compute client not initialized")
 	}
-	
-	// List all volumes
-	tritonVolumes, err := c.computeClient.Volumes().List(ctx, &compute.ListVolumesInput{})
-	
+
+	// CloudAPI's ListVolumes only accepts the name, size, state, type and
+	// predicate parameters
+	query := &url.Values{}
+	query.Set("type", TritonVolumeTypeNFS)
+	if filter.Name != "" {
+		query.Set("name", filter.Name)
+	}
+	if filter.State != "" {
+		query.Set("state", filter.State)
+	}
+
+	var tritonVolumes []*compute.Volume
+	err := callCloudAPI(ctx, "ListVolumes", true, func(ctx context.Context) error {
+		resp, err := c.computeClient.Client.ExecuteRequest(ctx, client.RequestInput{
+			Method: http.MethodGet,
+			Path:   path.Join("/", c.accountID, "volumes"),
+			Query:  query,
+		})
+		if resp != nil {
+			defer resp.Close()
+		}
+		if err != nil {
+			return err
+		}
+		tritonVolumes = nil
+		return json.NewDecoder(resp).Decode(&tritonVolumes)
+	})
 	if err != nil {
-		logrus.Errorf("Failed to list volumes using triton-go client: %v", err)
+		logrus.Errorf("Failed to list volumes matching %s: %v", query.Encode(), err)
 		return nil, err
 	}
-	
-	logrus.Infof("Found %d volumes from Triton API", len(tritonVolumes))
-	
-	// Convert to our internal NFSVolume type
+	logrus.Debugf("Found %d volumes matching %s", len(tritonVolumes), query.Encode())
+
 	var volumes []*NFSVolume
 	for _, vol := range tritonVolumes {
 		// Skip non-tritonnfs volumes
-		if vol.Type != "tritonnfs" {
+		if vol.Type != TritonVolumeTypeNFS {
 			logrus.Warnf("Skipping volume %s with type %s (only tritonnfs is supported)", vol.ID, vol.Type)
 			continue
 		}
-		
-		// Check if FileSystemPath exists
-		if vol.FileSystemPath == "" {
-			logrus.Warnf("Volume %s has no FileSystemPath", vol.ID)
-		} else {
-			logrus.Infof("Volume %s has FileSystemPath: %s", vol.ID, vol.FileSystemPath)
-		}
-		
-		nfsVolume := &NFSVolume{
-			ID:             vol.ID,
-			Name:           vol.Name,
-			State:          vol.State,
-			Type:           vol.Type,
-			Size:           int64(vol.Size) * 1024 * 1024, // Convert MB to bytes
-			MountPoint:     vol.FileSystemPath,
-			FileSystemPath: vol.FileSystemPath, // This contains the full NFS path including IP
-			Created:        time.Now(), // No Created field in compute.Volume
-			Tags:           vol.Tags,
-			Networks:       []Network{}, // Initialize with empty slice
+		volume := nfsVolumeFromTriton(vol)
+		if !filter.Matches(volume) {
+			continue
 		}
-		
-		// Add networks from the volume
-		for _, netID := range vol.Networks {
-			nfsVolume.Networks = append(nfsVolume.Networks, Network{
-				ID:   netID,
-				Name: "network",
-				IP:   "",
-			})
+		volumes = append(volumes, volume)
+	}
+	if len(volumes) > 0 {
+		if err := c.describeNetworks(ctx, volumes...); err != nil {
+			return nil, err
 		}
-		
-		volumes = append(volumes, nfsVolume)
 	}
-	
 	return volumes, nil
 }
 
-// waitForVolumeReady polls the volume until it reaches the "ready" state
-func (c *TritonClient) waitForVolumeReady(ctx context.Context, volumeID string) (*compute.Volume, error) {
-	// Define polling parameters
-	maxAttempts := 30
-	pollInterval := 10 * time.Second
-	
-	// Poll for volume status
-	for attempt := 0; attempt < maxAttempts; attempt++ {
-		// Check if context is cancelled
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		default:
+// nfsVolumeFromTriton converts a CloudAPI volume to an NFSVolume
+func nfsVolumeFromTriton(vol *compute.Volume) *NFSVolume {
+	nfsVolume := &NFSVolume{
+		ID:             vol.ID,
+		Name:           vol.Name,
+		State:          vol.State,
+		Type:           vol.Type,
+		Size:           int64(vol.Size) * 1024 * 1024, // Convert MB to bytes
+		MountPoint:     vol.FileSystemPath,
+		FileSystemPath: vol.FileSystemPath,
+		Created:        time.Now(), // No Created field in compute.Volume
+		Tags:           vol.Tags,
+		Networks:       []Network{},
+	}
+	for _, netID := range vol.Networks {
+		nfsVolume.Networks = append(nfsVolume.Networks, Network{ID: netID})
+	}
+	return nfsVolume
+}
+
+// describeNetworks fills in the networks of volumes from the network list.
+// Only the network list shows whether a volume is served on its mount
+// network, so a failure to list networks is returned as Unavailable when a
+// volume has a mount network. Other volumes are reported with network IDs only.
+func (c *TritonClient) describeNetworks(ctx context.Context, volumes ...*NFSVolume) error {
+	networks, err := c.ListNetworks(ctx)
+	if err != nil {
+		for _, volume := range volumes {
+			if volume.Tags[tagMountNetwork] != "" {
+				return status.Errorf(codes.Unavailable, "Failed to list the networks of volume %s: %v", volume.Name, err)
+			}
 		}
-		
-		// Get current volume status
-		volume, err := c.computeClient.Volumes().Get(ctx, &compute.GetVolumeInput{
-			ID: volumeID,
+		logrus.Warnf("Failed to list networks, volume networks are reported by ID only: %v", err)
+		return nil
+	}
+	for _, volume := range volumes {
+		describeNetworks(volume, networks)
+	}
+	return nil
+}
+
+// ListNetworks lists the networks available to the account. Networks rarely
+// change, so the list is cached for networksCacheTTL.
+func (c *TritonClient) ListNetworks(ctx context.Context) ([]*Network, error) {
+	c.networksMu.Lock()
+	defer c.networksMu.Unlock()
+
+	if c.networks != nil && time.Since(c.networksFetched) < networksCacheTTL {
+		return c.networks, nil
+	}
+
+	var networks []*Network
+	err := callCloudAPI(ctx, "ListNetworks", true, func(ctx context.Context) error {
+		resp, err := c.computeClient.Client.ExecuteRequest(ctx, client.RequestInput{
+			Method: http.MethodGet,
+			Path:   path.Join("/", c.accountID, "networks"),
 		})
-		
-		if err != nil {
-			return nil, fmt.Errorf("failed to get volume status: %v", err)
+		if resp != nil {
+			defer resp.Close()
 		}
-		
-		// Check if volume is ready
-		if volume.State == "ready" {
-			// Add more debugging for filesystem_path
-			logrus.Infof("Volume %s is ready with FileSystemPath: %s", volumeID, volume.FileSystemPath)
-			if volume.FileSystemPath == "" {
-				logrus.Warnf("Volume %s is ready but has no FileSystemPath", volumeID)
-			}
-			return volume, nil
-		}
-		
-		// Check if volume is in a failed state
-		if volume.State == "failed" {
-			return nil, fmt.Errorf("volume reached failed state")
+		if err != nil {
+			return err
 		}
-		
-		// Log the current state and continue polling
-		logrus.Infof("Volume %s is in %s state, waiting %v before checking again (attempt %d/%d)", 
-			volumeID, volume.State, pollInterval, attempt+1, maxAttempts)
-		
-		// Wait before next attempt
-		time.Sleep(pollInterval)
+		networks = nil
+		return json.NewDecoder(resp).Decode(&networks)
+	})
+	if err != nil {
+		return nil, err
 	}
-	
-	return nil, fmt.Errorf("timed out waiting for volume to be ready after %d attempts", maxAttempts)
-}
\ No newline at end of file
+
+	c.networks = networks
+	c.networksFetched = time.Now()
+	return networks, nil
+}
+
+// getVolume fetches a volume from CloudAPI, retrying transient errors
+func (c *TritonClient) getVolume(ctx context.Context, id string) (*compute.Volume, error) {
+	var volume *compute.Volume
+	err := callCloudAPI(ctx, "GetVolume", true, func(ctx context.Context) error {
+		var err error
+		volume, err = c.computeClient.Volumes().Get(ctx, &compute.GetVolumeInput{
+			ID: id,
+		})
+		return err
+	})
+	return volume, err
+}
@@ -0,0 +1,167 @@
+package driver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/joyent/tritonnfs-csi/pkg/fakecloudapi"
+	"golang.org/x/crypto/ssh"
+)
+
+const testAccount = "test-account"
+
+// newTestKey returns a PEM encoded RSA private key and its SSH public key
+func newTestKey(t *testing.T) ([]byte, ssh.PublicKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	publicKey, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("NewPublicKey: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), publicKey
+}
+
+// waitForVolumeState polls a volume until it is in state
+func waitForVolumeState(t *testing.T, client *TritonClient, id, state string) *NFSVolume {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		volume, err := client.GetVolume(context.Background(), id)
+		if err != nil {
+			t.Fatalf("GetVolume: %v", err)
+		}
+		if volume.State == state {
+			return volume
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("volume %s is still %s, expected %s", id, volume.State, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTritonClientFakeCloudAPI(t *testing.T) {
+	privateKey, publicKey := newTestKey(t)
+	server, cloudAPI := fakecloudapi.NewTestServer(fakecloudapi.Options{
+		Account:     testAccount,
+		PublicKey:   publicKey,
+		ReadyAfter:  50 * time.Millisecond,
+		DeleteAfter: 50 * time.Millisecond,
+	})
+	defer server.Close()
+	ctx := context.Background()
+
+	client, err := NewTritonClientWithKey(ctx, server.URL, testAccount, ssh.FingerprintLegacyMD5(publicKey), privateKey)
+	if err != nil {
+		t.Fatalf("NewTritonClientWithKey: %v", err)
+	}
+
+	sizes, err := client.ListVolumeSizes(ctx)
+	if err != nil {
+		t.Fatalf("ListVolumeSizes: %v", err)
+	}
+	if len(sizes) == 0 || sizes[0] != 10*gib {
+		t.Fatalf("expected the stock sizes starting at 10 GiB, got %v", sizes)
+	}
+
+	// New volumes are created, then become ready
+	created, err := client.CreateVolume(ctx, &NFSVolumeRequest{Name: "pvc-1", Size: 10 * gib, Type: TritonVolumeTypeNFS, Tags: map[string]string{"team": "a"}})
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	if created.State != VolumeStateCreating {
+		t.Errorf("expected a new volume to be %s, got %s", VolumeStateCreating, created.State)
+	}
+	volume := waitForVolumeState(t, client, created.ID, VolumeStateReady)
+	network := fakecloudapi.DefaultNetworks()[0]
+	if want := fmt.Sprintf("%s:/exports/%s", network.NFSServerIP, created.ID); volume.FileSystemPath != want {
+		t.Errorf("expected filesystem_path %s, got %s", want, volume.FileSystemPath)
+	}
+	if len(volume.Networks) != 1 || volume.Networks[0].IP != network.NFSServerIP {
+		t.Errorf("expected the volume to be served at %s on %s, got %+v", network.NFSServerIP, network.Name, volume.Networks)
+	}
+
+	// Expanded volumes are resizing, then ready with the new size
+	expanded, err := client.ExpandVolume(ctx, created.ID, 15*gib)
+	if err != nil {
+		t.Fatalf("ExpandVolume: %v", err)
+	}
+	if expanded.State != VolumeStateResizing {
+		t.Errorf("expected an expanded volume to be %s, got %s", VolumeStateResizing, expanded.State)
+	}
+	volume = waitForVolumeState(t, client, created.ID, VolumeStateReady)
+	if volume.Size != 20*gib {
+		t.Errorf("expected the volume to be resized to 20 GiB, got %d bytes", volume.Size)
+	}
+
+	volumes, err := client.FindVolumes(ctx, &VolumeFilter{Name: "pvc-1"})
+	if err != nil {
+		t.Fatalf("FindVolumes: %v", err)
+	}
+	if len(volumes) != 1 || volumes[0].ID != created.ID {
+		t.Errorf("expected to find volume %s, got %v", created.ID, volumes)
+	}
+
+	// Tags are set without dropping the others
+	tagged, err := client.SetVolumeTags(ctx, created.ID, map[string]string{tagCopyComplete: "2026-01-01T00:00:00Z"})
+	if err != nil {
+		t.Fatalf("SetVolumeTags: %v", err)
+	}
+	if tagged.Tags[tagCopyComplete] == "" || tagged.Tags["team"] != "a" {
+		t.Errorf("expected the new tag next to the existing ones, got %v", tagged.Tags)
+	}
+
+	// Deleted volumes are deleting, then gone
+	if err := client.DeleteVolume(ctx, created.ID); err != nil {
+		t.Fatalf("DeleteVolume: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(cloudAPI.Volumes()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the volume to be deleted, got %+v", cloudAPI.Volumes())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := client.GetVolume(ctx, created.ID); !IsNotFound(err) {
+		t.Errorf("expected volume %s to be gone, got %v", created.ID, err)
+	}
+}
+
+func TestFakeCloudAPIRejectsBadSignatures(t *testing.T) {
+	_, publicKey := newTestKey(t)
+	server, _ := fakecloudapi.NewTestServer(fakecloudapi.Options{Account: testAccount, PublicKey: publicKey})
+	defer server.Close()
+
+	// A signature that claims the server's key but was not made with it
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/"+testAccount+"/volumes", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Date", time.Now().UTC().Format(time.RFC1123))
+	req.Header.Set("Authorization", fmt.Sprintf(`Signature keyId="/%s/keys/%s",algorithm="rsa-sha256",headers="date",signature="c2lnbmF0dXJl"`,
+		testAccount, ssh.FingerprintLegacyMD5(publicKey)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// A client with another key is rejected when it connects
+	otherKey, otherPublicKey := newTestKey(t)
+	if _, err := NewTritonClientWithKey(context.Background(), server.URL, testAccount, ssh.FingerprintLegacyMD5(otherPublicKey), otherKey); err == nil {
+		t.Error("expected a client with an unknown key to be rejected")
+	}
+}
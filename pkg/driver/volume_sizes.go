@@ -0,0 +1,38 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// volumeSizesCacheTTL is how long the Triton volume size catalogue is cached
+const volumeSizesCacheTTL = time.Hour
+
+// ErrVolumeSizeNotOffered is returned when no volume size offered by Triton
+// satisfies a request
+var ErrVolumeSizeNotOffered = errors.New("no volume size offered by Triton satisfies the request")
+
+// selectVolumeSize returns the smallest offered size that holds at least
+// requiredBytes without exceeding limitBytes. A limitBytes of 0 means no limit.
+// sizes must be sorted smallest first.
+func selectVolumeSize(sizes []int64, requiredBytes, limitBytes int64) (int64, error) {
+	if len(sizes) == 0 {
+		return 0, fmt.Errorf("%w: Triton offers no volume sizes", ErrVolumeSizeNotOffered)
+	}
+
+	for _, size := range sizes {
+		if size < requiredBytes {
+			continue
+		}
+		if limitBytes > 0 && size > limitBytes {
+			break
+		}
+		return size, nil
+	}
+
+	if limitBytes > 0 {
+		return 0, fmt.Errorf("%w: no offered size between %d and %d bytes", ErrVolumeSizeNotOffered, requiredBytes, limitBytes)
+	}
+	return 0, fmt.Errorf("%w: %d bytes requested, largest offered size is %d bytes", ErrVolumeSizeNotOffered, requiredBytes, sizes[len(sizes)-1])
+}
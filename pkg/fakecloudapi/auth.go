@@ -0,0 +1,190 @@
+package fakecloudapi
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// MaxClockSkew is the largest difference between the signed Date header and
+// the server clock that is accepted, matching CloudAPI
+const MaxClockSkew = 300 * time.Second
+
+// ParsePublicKey parses a public key in OpenSSH authorized_keys format or as a
+// PEM encoded PKIX public key
+func ParsePublicKey(data []byte) (ssh.PublicKey, error) {
+	if key, _, _, _, err := ssh.ParseAuthorizedKey(data); err == nil {
+		return key, nil
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("public key is neither in authorized_keys nor PEM format")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PEM public key: %v", err)
+	}
+
+	return ssh.NewPublicKey(pub)
+}
+
+// signatureHeader holds the parameters of an HTTP Signature Authorization header
+type signatureHeader struct {
+	keyID     string
+	algorithm string
+	headers   []string
+	signature []byte
+}
+
+// parseSignatureHeader parses an Authorization header of the form
+// Signature keyId="/account/keys/fp",algorithm="rsa-sha256",headers="date",signature="..."
+func parseSignatureHeader(value string) (*signatureHeader, error) {
+	if !strings.HasPrefix(value, "Signature ") {
+		return nil, fmt.Errorf("authorization scheme must be Signature")
+	}
+
+	params := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(value, "Signature "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("malformed signature parameter %q", part)
+		}
+		params[kv[0]] = strings.Trim(kv[1], `"`)
+	}
+
+	header := &signatureHeader{
+		keyID:     params["keyId"],
+		algorithm: strings.ToLower(params["algorithm"]),
+		headers:   []string{"date"},
+	}
+	if header.keyID == "" || header.algorithm == "" || params["signature"] == "" {
+		return nil, fmt.Errorf("keyId, algorithm and signature are required")
+	}
+	if h := params["headers"]; h != "" {
+		header.headers = strings.Fields(strings.ToLower(h))
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return nil, fmt.Errorf("signature is not valid base64: %v", err)
+	}
+	header.signature = signature
+
+	return header, nil
+}
+
+// verifyRequest checks the HTTP Signature of r against the configured public key
+func (s *Server) verifyRequest(r *http.Request, account string) error {
+	header, err := parseSignatureHeader(r.Header.Get("Authorization"))
+	if err != nil {
+		return err
+	}
+
+	// keyId is /:account/keys/:fingerprint or /:account/users/:user/keys/:fingerprint
+	parts := strings.Split(strings.TrimPrefix(header.keyID, "/"), "/")
+	if len(parts) < 3 || parts[len(parts)-2] != "keys" {
+		return fmt.Errorf("malformed keyId %q", header.keyID)
+	}
+	if parts[0] != account {
+		return fmt.Errorf("keyId account %q does not match %q", parts[0], account)
+	}
+	fingerprint := parts[len(parts)-1]
+	if fingerprint != ssh.FingerprintLegacyMD5(s.publicKey) && fingerprint != ssh.FingerprintSHA256(s.publicKey) {
+		return fmt.Errorf("unknown key %s", fingerprint)
+	}
+
+	// triton-go formats the Date header with time.RFC1123 in UTC rather than GMT
+	date, err := time.Parse(time.RFC1123, r.Header.Get("Date"))
+	if err != nil {
+		if date, err = http.ParseTime(r.Header.Get("Date")); err != nil {
+			return fmt.Errorf("missing or invalid Date header: %v", err)
+		}
+	}
+	if skew := time.Since(date); skew > MaxClockSkew || skew < -MaxClockSkew {
+		return fmt.Errorf("clock skew of %v exceeds %v", skew, MaxClockSkew)
+	}
+
+	var lines []string
+	for _, name := range header.headers {
+		switch name {
+		case "request-line":
+			lines = append(lines, fmt.Sprintf("%s %s %s", r.Method, r.URL.RequestURI(), r.Proto))
+		case "(request-target)":
+			lines = append(lines, fmt.Sprintf("(request-target): %s %s", strings.ToLower(r.Method), r.URL.RequestURI()))
+		default:
+			lines = append(lines, fmt.Sprintf("%s: %s", name, r.Header.Get(name)))
+		}
+	}
+
+	return verifySignature(s.publicKey, header.algorithm, []byte(strings.Join(lines, "\n")), header.signature)
+}
+
+// verifySignature verifies an rsa-* or ecdsa-* signature over data
+func verifySignature(key ssh.PublicKey, algorithm string, data, signature []byte) error {
+	cryptoKey, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported public key type %s", key.Type())
+	}
+
+	keyType, hashName, ok := strings.Cut(algorithm, "-")
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+
+	var hash crypto.Hash
+	switch hashName {
+	case "sha1":
+		hash = crypto.SHA1
+	case "sha256":
+		hash = crypto.SHA256
+	case "sha384":
+		hash = crypto.SHA384
+	case "sha512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported hash algorithm %q", hashName)
+	}
+	h := hash.New()
+	h.Write(data)
+	digest := h.Sum(nil)
+
+	switch pub := cryptoKey.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		if keyType != "rsa" {
+			return fmt.Errorf("algorithm %q does not match RSA key", algorithm)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, signature); err != nil {
+			return fmt.Errorf("invalid signature: %v", err)
+		}
+	case *ecdsa.PublicKey:
+		if keyType != "ecdsa" {
+			return fmt.Errorf("algorithm %q does not match ECDSA key", algorithm)
+		}
+		var sig struct {
+			R, S *big.Int
+		}
+		if _, err := asn1.Unmarshal(signature, &sig); err != nil {
+			return fmt.Errorf("invalid ECDSA signature encoding: %v", err)
+		}
+		if !ecdsa.Verify(pub, digest, sig.R, sig.S) {
+			return fmt.Errorf("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %s", key.Type())
+	}
+
+	return nil
+}
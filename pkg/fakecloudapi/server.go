@@ -0,0 +1,562 @@
+// Package fakecloudapi provides a local stand-in for the parts of the Triton
+// CloudAPI used by the CSI driver: volumes, networks and volume sizes. It lets
+// the triton-go based TritonClient be exercised end to end without access to a
+// Triton datacenter.
+package fakecloudapi
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// Volume is a Triton volume as returned by CloudAPI. Size is in megabytes.
+type Volume struct {
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	Owner          string            `json:"owner_uuid"`
+	Type           string            `json:"type"`
+	FileSystemPath string            `json:"filesystem_path"`
+	Size           int64             `json:"size"`
+	State          string            `json:"state"`
+	Networks       []string          `json:"networks"`
+	Refs           []string          `json:"refs"`
+	Tags           map[string]string `json:"tags"`
+	Created        string            `json:"create_timestamp"`
+
+	created  time.Time
+	readyAt  time.Time
+	deleteAt time.Time
+}
+
+// Network is a Triton network as returned by CloudAPI
+type Network struct {
+	ID               string   `json:"id"`
+	Name             string   `json:"name"`
+	Public           bool     `json:"public"`
+	Fabric           bool     `json:"fabric"`
+	Description      string   `json:"description,omitempty"`
+	Subnet           string   `json:"subnet"`
+	ProvisionStartIP string   `json:"provision_start_ip"`
+	ProvisionEndIP   string   `json:"provision_end_ip"`
+	Gateway          string   `json:"gateway,omitempty"`
+	Resolvers        []string `json:"resolvers"`
+
+	// NFSServerIP is the address NFS volumes on this network are served from
+	NFSServerIP string `json:"-"`
+}
+
+// VolumeSize is an entry of the CloudAPI volume size catalogue. Size is in megabytes.
+type VolumeSize struct {
+	Size        int64  `json:"size"`
+	Description string `json:"description"`
+}
+
+// Options configures a Server
+type Options struct {
+	// Account is the only account name the server answers for
+	Account string
+
+	// PublicKey is used to verify the HTTP Signature of every request.
+	// Verification is disabled when nil.
+	PublicKey ssh.PublicKey
+
+	// ReadyAfter is how long a new or resized volume stays in a transitional state
+	ReadyAfter time.Duration
+
+	// DeleteAfter is how long a deleted volume stays in the deleting state
+	DeleteAfter time.Duration
+
+	// Networks defaults to DefaultNetworks()
+	Networks []Network
+
+	// VolumeSizes defaults to DefaultVolumeSizes()
+	VolumeSizes []VolumeSize
+}
+
+// DefaultNetworks returns a single fabric network with an NFS server address
+func DefaultNetworks() []Network {
+	return []Network{
+		{
+			ID:               "5f5e0c5c-5d5a-4b8b-9e43-6fd0b4c1a001",
+			Name:             "My-Fabric-Network",
+			Fabric:           true,
+			Description:      "Default fabric network",
+			Subnet:           "192.168.128.0/22",
+			ProvisionStartIP: "192.168.128.5",
+			ProvisionEndIP:   "192.168.131.250",
+			Gateway:          "192.168.128.1",
+			Resolvers:        []string{"8.8.8.8", "8.8.4.4"},
+			NFSServerIP:      "192.168.128.10",
+		},
+	}
+}
+
+// DefaultVolumeSizes returns the catalogue offered by a stock Triton
+// installation: 10 to 100 GiB in 10 GiB steps, then up to 1000 GiB in 100 GiB steps
+func DefaultVolumeSizes() []VolumeSize {
+	var sizes []VolumeSize
+	for gib := int64(10); gib <= 1000; {
+		sizes = append(sizes, VolumeSize{Size: gib * 1024, Description: fmt.Sprintf("%d GiB", gib)})
+		if gib < 100 {
+			gib += 10
+		} else {
+			gib += 100
+		}
+	}
+	return sizes
+}
+
+// Server is an http.Handler implementing the volume related CloudAPI endpoints
+type Server struct {
+	account     string
+	publicKey   ssh.PublicKey
+	readyAfter  time.Duration
+	deleteAfter time.Duration
+	networks    []Network
+	sizes       []VolumeSize
+
+	mu      sync.Mutex
+	volumes map[string]*Volume
+}
+
+// NewServer creates a Server with the given options
+func NewServer(opts Options) *Server {
+	s := &Server{
+		account:     opts.Account,
+		publicKey:   opts.PublicKey,
+		readyAfter:  opts.ReadyAfter,
+		deleteAfter: opts.DeleteAfter,
+		networks:    opts.Networks,
+		sizes:       opts.VolumeSizes,
+		volumes:     make(map[string]*Volume),
+	}
+	if len(s.networks) == 0 {
+		s.networks = DefaultNetworks()
+	}
+	if len(s.sizes) == 0 {
+		s.sizes = DefaultVolumeSizes()
+	}
+	return s
+}
+
+// NewTestServer starts an httptest.Server backed by a new Server. The caller
+// must Close the returned httptest.Server.
+func NewTestServer(opts Options) (*httptest.Server, *Server) {
+	s := NewServer(opts)
+	return httptest.NewServer(s), s
+}
+
+// Volumes returns a copy of every volume the server knows about, in creation order
+func (s *Server) Volumes() []Volume {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance()
+
+	volumes := make([]Volume, 0, len(s.volumes))
+	for _, v := range s.volumes {
+		volumes = append(volumes, *v)
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].created.Before(volumes[j].created)
+	})
+	return volumes
+}
+
+// SetVolumeState forces the state of an existing volume, e.g. to "failed"
+func (s *Server) SetVolumeState(id, state string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.volumes[id]
+	if !ok {
+		return fmt.Errorf("volume %s not found", id)
+	}
+	v.State = state
+	v.readyAt = time.Time{}
+	return nil
+}
+
+// ServeHTTP routes a CloudAPI request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logrus.Debugf("CloudAPI request: %s %s", r.Method, r.URL.RequestURI())
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("%s does not exist", r.URL.Path))
+		return
+	}
+
+	if s.publicKey != nil {
+		if err := s.verifyRequest(r, parts[0]); err != nil {
+			logrus.Warnf("CloudAPI request signature rejected: %v", err)
+			writeError(w, http.StatusUnauthorized, "InvalidCredentials", err.Error())
+			return
+		}
+	}
+	if parts[0] != s.account {
+		writeError(w, http.StatusForbidden, "NotAuthorized", fmt.Sprintf("not authorized for account %s", parts[0]))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance()
+
+	switch {
+	case parts[1] == "volumes" && len(parts) == 2 && r.Method == http.MethodGet:
+		s.listVolumes(w, r)
+	case parts[1] == "volumes" && len(parts) == 2 && r.Method == http.MethodPost:
+		s.createVolume(w, r)
+	case parts[1] == "volumes" && len(parts) == 3 && r.Method == http.MethodGet:
+		s.getVolume(w, parts[2])
+	case parts[1] == "volumes" && len(parts) == 3 && r.Method == http.MethodPost:
+		s.updateVolume(w, r, parts[2])
+	case parts[1] == "volumes" && len(parts) == 3 && r.Method == http.MethodPut:
+		s.replaceVolumeTags(w, r, parts[2])
+	case parts[1] == "volumes" && len(parts) == 3 && r.Method == http.MethodDelete:
+		s.deleteVolume(w, parts[2])
+	case parts[1] == "volumesizes" && len(parts) == 2 && r.Method == http.MethodGet:
+		s.listVolumeSizes(w, r)
+	case parts[1] == "networks" && len(parts) == 2 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.networks)
+	case parts[1] == "networks" && len(parts) == 3 && r.Method == http.MethodGet:
+		s.getNetwork(w, parts[2])
+	default:
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("%s %s does not exist", r.Method, r.URL.Path))
+	}
+}
+
+// listVolumesParams are the query parameters CloudAPI's ListVolumes accepts
+var listVolumesParams = map[string]bool{
+	"name":      true,
+	"predicate": true,
+	"size":      true,
+	"state":     true,
+	"type":      true,
+}
+
+func (s *Server) listVolumes(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	for key := range query {
+		if !listVolumesParams[key] {
+			writeError(w, http.StatusConflict, "InvalidArgument", fmt.Sprintf("%s: unknown parameter", key))
+			return
+		}
+	}
+	if query.Get("predicate") != "" {
+		writeError(w, http.StatusConflict, "InvalidArgument", "predicate: not supported by the fake CloudAPI")
+		return
+	}
+
+	volumes := []*Volume{}
+	for _, v := range s.volumes {
+		if name := query.Get("name"); name != "" && v.Name != name {
+			continue
+		}
+		if state := query.Get("state"); state != "" && v.State != state {
+			continue
+		}
+		if typ := query.Get("type"); typ != "" && v.Type != typ {
+			continue
+		}
+		if size := query.Get("size"); size != "" && strconv.FormatInt(v.Size, 10) != size {
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].created.Before(volumes[j].created)
+	})
+
+	writeJSON(w, http.StatusOK, volumes)
+}
+
+func (s *Server) createVolume(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name     string            `json:"name"`
+		Size     int64             `json:"size"`
+		Networks []string          `json:"networks"`
+		Type     string            `json:"type"`
+		Tags     map[string]string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "BadRequest", fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+
+	if input.Type == "" {
+		input.Type = "tritonnfs"
+	}
+	if input.Type != "tritonnfs" {
+		writeError(w, http.StatusConflict, "InvalidArgument", fmt.Sprintf("type: volume type %q is not supported, must be one of: tritonnfs", input.Type))
+		return
+	}
+
+	if input.Size == 0 {
+		input.Size = s.sizes[0].Size
+	}
+	if !s.sizeOffered(input.Size) {
+		writeError(w, http.StatusConflict, "InvalidArgument", fmt.Sprintf("size: volume size not available, must be one of: %s", s.sizeList()))
+		return
+	}
+
+	if input.Name == "" {
+		id, err := newUUID()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		input.Name = id
+	}
+	for _, v := range s.volumes {
+		if v.Name == input.Name {
+			writeError(w, http.StatusConflict, "VolumeAlreadyExists", fmt.Sprintf("volume with name %s already exists", input.Name))
+			return
+		}
+	}
+
+	if len(input.Networks) == 0 {
+		input.Networks = []string{s.networks[0].ID}
+	}
+	var network *Network
+	for _, netID := range input.Networks {
+		n := s.findNetwork(netID)
+		if n == nil {
+			writeError(w, http.StatusConflict, "InvalidArgument", fmt.Sprintf("networks: network %s does not exist", netID))
+			return
+		}
+		if network == nil {
+			network = n
+		}
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	if input.Tags == nil {
+		input.Tags = map[string]string{}
+	}
+
+	now := time.Now()
+	v := &Volume{
+		ID:             id,
+		Name:           input.Name,
+		Owner:          s.account,
+		Type:           input.Type,
+		FileSystemPath: fmt.Sprintf("%s:/exports/%s", network.NFSServerIP, id),
+		Size:           input.Size,
+		State:          "creating",
+		Networks:       input.Networks,
+		Refs:           []string{},
+		Tags:           input.Tags,
+		Created:        now.UTC().Format(time.RFC3339Nano),
+		created:        now,
+		readyAt:        now.Add(s.readyAfter),
+	}
+	s.volumes[id] = v
+
+	writeJSON(w, http.StatusCreated, v)
+}
+
+func (s *Server) getVolume(w http.ResponseWriter, id string) {
+	v, ok := s.volumes[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("volume %s not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+func (s *Server) updateVolume(w http.ResponseWriter, r *http.Request, id string) {
+	v, ok := s.volumes[id]
+	if !ok || v.State == "deleting" {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("volume %s not found", id))
+		return
+	}
+
+	var input struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "BadRequest", fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+
+	if input.Size != 0 && input.Size != v.Size {
+		if v.State != "ready" {
+			writeError(w, http.StatusConflict, "VolumeNotReady", fmt.Sprintf("volume %s is %s, it must be ready to be resized", id, v.State))
+			return
+		}
+		if input.Size < v.Size {
+			writeError(w, http.StatusConflict, "InvalidArgument", fmt.Sprintf("size: volumes cannot be shrunk from %d to %d", v.Size, input.Size))
+			return
+		}
+		if !s.sizeOffered(input.Size) {
+			writeError(w, http.StatusConflict, "InvalidArgument", fmt.Sprintf("size: volume size not available, must be one of: %s", s.sizeList()))
+			return
+		}
+	}
+
+	if input.Name != "" && input.Name != v.Name {
+		for _, other := range s.volumes {
+			if other.Name == input.Name {
+				writeError(w, http.StatusConflict, "VolumeAlreadyExists", fmt.Sprintf("volume with name %s already exists", input.Name))
+				return
+			}
+		}
+		v.Name = input.Name
+	}
+
+	if input.Size != 0 && input.Size != v.Size {
+		v.Size = input.Size
+		v.State = "resizing"
+		v.readyAt = time.Now().Add(s.readyAfter)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// replaceVolumeTags replaces the tags of a volume with those of the body
+func (s *Server) replaceVolumeTags(w http.ResponseWriter, r *http.Request, id string) {
+	v, ok := s.volumes[id]
+	if !ok || v.State == "deleting" {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("volume %s not found", id))
+		return
+	}
+
+	var input struct {
+		Tags map[string]string `json:"tags"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "BadRequest", fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+
+	v.Tags = input.Tags
+	if v.Tags == nil {
+		v.Tags = map[string]string{}
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+func (s *Server) deleteVolume(w http.ResponseWriter, id string) {
+	v, ok := s.volumes[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("volume %s not found", id))
+		return
+	}
+	if len(v.Refs) > 0 {
+		writeError(w, http.StatusConflict, "VolumeInUse", fmt.Sprintf("volume %s is used by %s", id, strings.Join(v.Refs, ", ")))
+		return
+	}
+
+	if v.State != "deleting" {
+		v.State = "deleting"
+		v.deleteAt = time.Now().Add(s.deleteAfter)
+	}
+	s.advance()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listVolumeSizes(w http.ResponseWriter, r *http.Request) {
+	if typ := r.URL.Query().Get("type"); typ != "" && typ != "tritonnfs" {
+		writeJSON(w, http.StatusOK, []VolumeSize{})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.sizes)
+}
+
+func (s *Server) getNetwork(w http.ResponseWriter, id string) {
+	n := s.findNetwork(id)
+	if n == nil {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("network %s not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, n)
+}
+
+// advance moves volumes through their asynchronous state transitions.
+// Callers must hold s.mu.
+func (s *Server) advance() {
+	now := time.Now()
+	for id, v := range s.volumes {
+		switch v.State {
+		case "creating", "resizing":
+			if !now.Before(v.readyAt) {
+				v.State = "ready"
+			}
+		case "deleting":
+			if !now.Before(v.deleteAt) {
+				delete(s.volumes, id)
+			}
+		}
+	}
+}
+
+func (s *Server) findNetwork(id string) *Network {
+	for i := range s.networks {
+		if s.networks[i].ID == id {
+			return &s.networks[i]
+		}
+	}
+	return nil
+}
+
+func (s *Server) sizeOffered(size int64) bool {
+	for _, vs := range s.sizes {
+		if vs.Size == size {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) sizeList() string {
+	var sizes []string
+	for _, vs := range s.sizes {
+		sizes = append(sizes, strconv.FormatInt(vs.Size, 10))
+	}
+	return strings.Join(sizes, ", ")
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Errorf("Failed to encode CloudAPI response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, errCode, message string) {
+	writeJSON(w, code, map[string]string{
+		"code":    errCode,
+		"message": message,
+	})
+}
+
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate UUID: %v", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}